@@ -0,0 +1,24 @@
+package main
+
+import "time"
+
+// clock abstracts time.Now so scheduling and staleness decisions can be
+// driven deterministically in tests instead of racing against the wall
+// clock.
+type clock interface {
+	Now() time.Time
+}
+
+// realClock is the clock NewMetricsCollector wires up outside of tests.
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+// now returns the current time via the collector's clock, defaulting to the
+// wall clock for collectors built as bare struct literals (as the tests do).
+func (mc *MetricsCollector) now() time.Time {
+	if mc.clock != nil {
+		return mc.clock.Now()
+	}
+	return time.Now()
+}
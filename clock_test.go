@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+)
+
+// mockClock is an injectable clock.Now() the tests can advance deterministically,
+// replacing the old time.Sleep-based races with direct control over "now".
+type mockClock struct {
+	now time.Time
+}
+
+func (c *mockClock) Now() time.Time { return c.now }
+
+func (c *mockClock) advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func TestRefreshTargetsEvictsStaleCachedEntriesAndSDTargets(t *testing.T) {
+	mockClient := &mockDockerClient{
+		containers: []container.Summary{
+			{
+				ID: "stale-container",
+				Labels: map[string]string{
+					"prometheus.auto.enable": "true",
+					"prometheus.auto.port":   "9000",
+				},
+			},
+		},
+		containerInfo: map[string]container.InspectResponse{
+			"stale-container": {
+				ContainerJSONBase: &container.ContainerJSONBase{ID: "stale-container"},
+				NetworkSettings: &container.NetworkSettings{
+					Networks: map[string]*network.EndpointSettings{"bridge": {IPAddress: "127.0.0.1"}},
+				},
+			},
+		},
+	}
+
+	clk := &mockClock{now: time.Unix(1000, 0)}
+	mc := &MetricsCollector{
+		dockerClient: mockClient,
+		labelFilter:  make(map[string]string),
+		clock:        clk,
+		StaleAfter:   time.Minute,
+		targets: map[string]*containerTarget{
+			"stale-container": {containerID: "stale-container", lastScrape: clk.now},
+		},
+	}
+
+	clk.advance(2 * time.Minute)
+	mc.refreshTargets(context.Background())
+
+	mc.mu.RLock()
+	_, stillCached := mc.targets["stale-container"]
+	sdCount := len(mc.sdTargets)
+	mc.mu.RUnlock()
+
+	if stillCached {
+		t.Error("expected the stale cached entry to be evicted")
+	}
+	if sdCount != 0 {
+		t.Errorf("expected stale target to be aged out of sdTargets, got %d entries", sdCount)
+	}
+}
+
+func TestRefreshTargetsKeepsFreshCachedEntries(t *testing.T) {
+	mockClient := &mockDockerClient{
+		containers: []container.Summary{
+			{
+				ID: "fresh-container",
+				Labels: map[string]string{
+					"prometheus.auto.enable": "true",
+					"prometheus.auto.port":   "9000",
+				},
+			},
+		},
+		containerInfo: map[string]container.InspectResponse{
+			"fresh-container": {
+				ContainerJSONBase: &container.ContainerJSONBase{ID: "fresh-container"},
+				NetworkSettings: &container.NetworkSettings{
+					Networks: map[string]*network.EndpointSettings{"bridge": {IPAddress: "127.0.0.1"}},
+				},
+			},
+		},
+	}
+
+	clk := &mockClock{now: time.Unix(1000, 0)}
+	mc := &MetricsCollector{
+		dockerClient: mockClient,
+		labelFilter:  make(map[string]string),
+		clock:        clk,
+		StaleAfter:   time.Minute,
+		targets: map[string]*containerTarget{
+			"fresh-container": {containerID: "fresh-container", lastScrape: clk.now},
+		},
+	}
+
+	clk.advance(10 * time.Second)
+	mc.refreshTargets(context.Background())
+
+	mc.mu.RLock()
+	_, stillCached := mc.targets["fresh-container"]
+	sdCount := len(mc.sdTargets)
+	mc.mu.RUnlock()
+
+	if !stillCached {
+		t.Error("expected the fresh cached entry to survive refresh")
+	}
+	if sdCount != 1 {
+		t.Errorf("expected the fresh target to remain in sdTargets, got %d entries", sdCount)
+	}
+}
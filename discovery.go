@@ -0,0 +1,412 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/fsnotify/fsnotify"
+	"gopkg.in/yaml.v3"
+)
+
+// Target is a single scrapeable endpoint discovered by a Discoverer,
+// carrying enough identifying information to label its metrics and enough
+// provenance to show where it came from.
+type Target struct {
+	ID      string
+	Name    string
+	Image   string
+	Address string // host:port
+	Labels  map[string]string
+	Source  string
+}
+
+// Discoverer finds scrapeable targets from one source of truth (a Docker
+// daemon, a Swarm cluster, a remote endpoint, a static file, ...). main
+// composes the enabled discoverers and refreshTargets merges their results.
+type Discoverer interface {
+	Discover(ctx context.Context) ([]Target, error)
+}
+
+// containerDisplayName prefers the container's first name (with the leading
+// slash Docker adds stripped) over its ID, matching what `docker ps` shows.
+func containerDisplayName(c container.Summary) string {
+	if len(c.Names) > 0 {
+		return strings.TrimPrefix(c.Names[0], "/")
+	}
+	return c.ID
+}
+
+func firstContainerIP(info container.InspectResponse) string {
+	if info.NetworkSettings == nil {
+		return ""
+	}
+	for _, network := range info.NetworkSettings.Networks {
+		if network.IPAddress != "" {
+			return network.IPAddress
+		}
+	}
+	return ""
+}
+
+func containerPort(labels map[string]string) string {
+	if port := labels["prometheus.auto.port"]; port != "" {
+		return port
+	}
+	return "80"
+}
+
+// dockerDiscoverer discovers prometheus.auto.enable containers on a single
+// Docker daemon. It's the original (and default) discovery mode, now
+// expressed as one Discoverer implementation among several.
+type dockerDiscoverer struct {
+	client DockerClient
+	source string
+	// labelFilter restricts discovery to containers matching every
+	// key/value pair, mirroring MetricsCollector.labelFilter.
+	labelFilter map[string]string
+}
+
+func newDockerDiscoverer(cli DockerClient, source string, labelFilter map[string]string) *dockerDiscoverer {
+	return &dockerDiscoverer{client: cli, source: source, labelFilter: labelFilter}
+}
+
+// filterEnabledContainers keeps only containers opted into discovery via
+// `prometheus.auto.enable=true` that also match every configured label
+// filter.
+func filterEnabledContainers(containers []container.Summary, labelFilter map[string]string) []container.Summary {
+	var enabled []container.Summary
+	for _, c := range containers {
+		if c.Labels["prometheus.auto.enable"] != "true" {
+			continue
+		}
+		if !matchesLabelFilter(c.Labels, labelFilter) {
+			continue
+		}
+		enabled = append(enabled, c)
+	}
+	return enabled
+}
+
+func (d *dockerDiscoverer) Discover(ctx context.Context) ([]Target, error) {
+	containers, err := d.client.ContainerList(ctx, container.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+
+	var targets []Target
+	for _, c := range filterEnabledContainers(containers, d.labelFilter) {
+		info, err := d.client.ContainerInspect(ctx, c.ID)
+		if err != nil {
+			log.Printf("Error inspecting container %s: %v", c.ID, err)
+			continue
+		}
+
+		ip := firstContainerIP(info)
+		if ip == "" {
+			log.Printf("No IP address found for container %s", c.ID)
+			continue
+		}
+
+		targets = append(targets, Target{
+			ID:      c.ID,
+			Name:    containerDisplayName(c),
+			Image:   c.Image,
+			Address: net.JoinHostPort(ip, containerPort(c.Labels)),
+			Labels:  c.Labels,
+			Source:  d.source,
+		})
+	}
+
+	return targets, nil
+}
+
+// metaLabels builds the synthetic __meta_docker_* label set relabel rules
+// act on for a discovered target, mirroring the labels a real Prometheus
+// docker_sd_config target would carry.
+func metaLabels(tgt Target) map[string]string {
+	labels := map[string]string{
+		"__meta_docker_container_id":   tgt.ID,
+		"__meta_docker_container_name": tgt.Name,
+		"__address__":                  tgt.Address,
+		"__metrics_path__":             "/metrics",
+	}
+	if host, _, err := net.SplitHostPort(tgt.Address); err == nil {
+		labels["__meta_docker_network_ip"] = host
+	}
+	for key, value := range tgt.Labels {
+		labels[metaLabelName(key)] = value
+	}
+	return labels
+}
+
+func matchesLabelFilter(labels, filter map[string]string) bool {
+	for key, value := range filter {
+		if labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// remoteEndpoint is one entry of the YAML file pointed to by
+// PROMETHEUS_DOCKER_ENDPOINTS_FILE.
+type remoteEndpoint struct {
+	Host string         `yaml:"host"`
+	TLS  *remoteTLSOpts `yaml:"tls,omitempty"`
+}
+
+type remoteTLSOpts struct {
+	CAFile             string `yaml:"ca_file,omitempty"`
+	CertFile           string `yaml:"cert_file,omitempty"`
+	KeyFile            string `yaml:"key_file,omitempty"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify,omitempty"`
+}
+
+type remoteEndpointsConfig struct {
+	Endpoints []remoteEndpoint `yaml:"endpoints"`
+}
+
+// loadRemoteEndpoints parses the multi-endpoint YAML config and builds one
+// dockerDiscoverer per configured Docker host.
+func loadRemoteEndpoints(path string, labelFilter map[string]string) ([]Discoverer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read endpoints file %s: %w", path, err)
+	}
+
+	var cfg remoteEndpointsConfig
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse endpoints file %s: %w", path, err)
+	}
+
+	var discoverers []Discoverer
+	for _, ep := range cfg.Endpoints {
+		opts := []client.Opt{client.WithHost(ep.Host), client.WithAPIVersionNegotiation()}
+		if ep.TLS != nil {
+			httpClient, err := remoteTLSHTTPClient(ep.TLS)
+			if err != nil {
+				return nil, fmt.Errorf("failed to configure TLS for %s: %w", ep.Host, err)
+			}
+			opts = append(opts, client.WithHTTPClient(httpClient))
+		}
+
+		cli, err := client.NewClientWithOpts(opts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Docker client for %s: %w", ep.Host, err)
+		}
+
+		discoverers = append(discoverers, newDockerDiscoverer(cli, "remote:"+ep.Host, labelFilter))
+	}
+
+	return discoverers, nil
+}
+
+// remoteTLSHTTPClient builds the *http.Client used to reach one remote Docker
+// endpoint, the same way remoteWriteHTTPClient does for remote write
+// endpoints: client.WithTLSClientConfig loads certificates from files but has
+// no way to honor insecure_skip_verify, so the tls.Config is built by hand
+// whenever TLS is configured at all.
+func remoteTLSHTTPClient(tlsOpts *remoteTLSOpts) (*http.Client, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: tlsOpts.InsecureSkipVerify}
+	if tlsOpts.CAFile != "" {
+		caCert, err := os.ReadFile(tlsOpts.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file %s: %w", tlsOpts.CAFile, err)
+		}
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM(caCert)
+		tlsConfig.RootCAs = pool
+	}
+	if tlsOpts.CertFile != "" && tlsOpts.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(tlsOpts.CertFile, tlsOpts.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate %s: %w", tlsOpts.CertFile, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}, nil
+}
+
+// fileSDEntry mirrors Prometheus's file_sd_config entry shape, so an
+// operator who already maintains file_sd targets for Prometheus can point
+// this collector at the same file.
+type fileSDEntry struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// fileDiscoverer serves a static target list loaded from a JSON file and
+// kept in sync with the file's contents. Callers are expected to refresh it
+// (e.g. via inotify) by calling reload whenever the file changes.
+type fileDiscoverer struct {
+	path string
+
+	mu      sync.RWMutex
+	targets []Target
+}
+
+func newFileDiscoverer(path string) (*fileDiscoverer, error) {
+	d := &fileDiscoverer{path: path}
+	if err := d.reload(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *fileDiscoverer) reload() error {
+	data, err := os.ReadFile(d.path)
+	if err != nil {
+		return fmt.Errorf("failed to read file SD target file %s: %w", d.path, err)
+	}
+
+	var entries []fileSDEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("failed to parse file SD target file %s: %w", d.path, err)
+	}
+
+	var targets []Target
+	for _, entry := range entries {
+		for _, addr := range entry.Targets {
+			targets = append(targets, Target{
+				ID:      addr,
+				Name:    addr,
+				Address: addr,
+				Labels:  entry.Labels,
+				Source:  "file:" + d.path,
+			})
+		}
+	}
+
+	d.mu.Lock()
+	d.targets = targets
+	d.mu.Unlock()
+	return nil
+}
+
+func (d *fileDiscoverer) Discover(ctx context.Context) ([]Target, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return append([]Target{}, d.targets...), nil
+}
+
+// watch reloads the target file whenever it changes on disk, so operators
+// don't have to restart the collector to pick up new static targets. Editors
+// commonly replace a file rather than writing into it in place, so the parent
+// directory is watched and its events are filtered down to the target file.
+func (d *fileDiscoverer) watch(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create file SD watcher: %w", err)
+	}
+
+	if err := watcher.Add(filepath.Dir(d.path)); err != nil {
+		watcher.Close()
+		return fmt.Errorf("failed to watch file SD directory: %w", err)
+	}
+
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) != filepath.Clean(d.path) {
+					continue
+				}
+				if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+					continue
+				}
+				if err := d.reload(); err != nil {
+					log.Printf("Error reloading file SD target file %s: %v", d.path, err)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("File SD watcher error: %v", err)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// configureDiscoverers composes the Discoverer set for the running process:
+// the local Docker daemon the collector already has a client for, plus any
+// remote endpoints (PROMETHEUS_DOCKER_ENDPOINTS_FILE) and static file targets
+// (PROMETHEUS_FILE_SD_PATH) the operator opted into.
+func configureDiscoverers(ctx context.Context, mc *MetricsCollector) ([]Discoverer, error) {
+	discoverers := []Discoverer{newDockerDiscoverer(mc.dockerClient, "docker", mc.labelFilter)}
+
+	if os.Getenv("PROMETHEUS_SWARM_MODE") == "true" {
+		mc.SwarmMode = true
+		discoverers = append(discoverers, newSwarmDiscoverer(mc.dockerClient, mc.labelFilter))
+	}
+
+	if endpointsFile := os.Getenv("PROMETHEUS_DOCKER_ENDPOINTS_FILE"); endpointsFile != "" {
+		remote, err := loadRemoteEndpoints(endpointsFile, mc.labelFilter)
+		if err != nil {
+			return nil, err
+		}
+		discoverers = append(discoverers, remote...)
+	}
+
+	if fileSDPath := os.Getenv("PROMETHEUS_FILE_SD_PATH"); fileSDPath != "" {
+		fd, err := newFileDiscoverer(fileSDPath)
+		if err != nil {
+			return nil, err
+		}
+		if err := fd.watch(ctx); err != nil {
+			log.Printf("Error watching file SD target file %s: %v", fileSDPath, err)
+		}
+		discoverers = append(discoverers, fd)
+	}
+
+	return discoverers, nil
+}
+
+// mergeTargets runs every discoverer, concatenates their results, and
+// de-duplicates by address so the same scrape target is never scraped
+// twice just because two discoverers both found it.
+func mergeTargets(ctx context.Context, discoverers []Discoverer) ([]Target, error) {
+	seen := make(map[string]bool)
+	var merged []Target
+
+	for _, d := range discoverers {
+		targets, err := d.Discover(ctx)
+		if err != nil {
+			log.Printf("Error running discoverer: %v", err)
+			continue
+		}
+		for _, t := range targets {
+			if seen[t.Address] {
+				continue
+			}
+			seen[t.Address] = true
+			merged = append(merged, t)
+		}
+	}
+
+	return merged, nil
+}
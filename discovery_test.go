@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+)
+
+func TestFilterEnabledContainers(t *testing.T) {
+	tests := []struct {
+		name          string
+		containers    []container.Summary
+		labelFilter   map[string]string
+		expectedCount int
+	}{
+		{
+			name: "discover prometheus enabled containers",
+			containers: []container.Summary{
+				{
+					ID: "container1",
+					Labels: map[string]string{
+						"prometheus.auto.enable": "true",
+						"prometheus.auto.port":   "9090",
+					},
+				},
+				{
+					ID: "container2",
+					Labels: map[string]string{
+						"prometheus.auto.enable": "false",
+					},
+				},
+				{
+					ID: "container3",
+					Labels: map[string]string{
+						"prometheus.auto.enable": "true",
+					},
+				},
+			},
+			expectedCount: 2,
+		},
+		{
+			name:          "no containers",
+			containers:    []container.Summary{},
+			expectedCount: 0,
+		},
+		{
+			name: "label filter matches",
+			containers: []container.Summary{
+				{
+					ID: "container1",
+					Labels: map[string]string{
+						"prometheus.auto.enable": "true",
+						"environment":            "production",
+						"service":                "api",
+					},
+				},
+				{
+					ID: "container2",
+					Labels: map[string]string{
+						"prometheus.auto.enable": "true",
+						"environment":            "staging",
+						"service":                "api",
+					},
+				},
+			},
+			labelFilter: map[string]string{
+				"environment": "production",
+			},
+			expectedCount: 1,
+		},
+		{
+			name: "multiple label filters",
+			containers: []container.Summary{
+				{
+					ID: "container1",
+					Labels: map[string]string{
+						"prometheus.auto.enable": "true",
+						"environment":            "production",
+						"service":                "api",
+					},
+				},
+				{
+					ID: "container2",
+					Labels: map[string]string{
+						"prometheus.auto.enable": "true",
+						"environment":            "production",
+						"service":                "worker",
+					},
+				},
+			},
+			labelFilter: map[string]string{
+				"environment": "production",
+				"service":     "api",
+			},
+			expectedCount: 1,
+		},
+		{
+			name: "label filter no matches",
+			containers: []container.Summary{
+				{
+					ID: "container1",
+					Labels: map[string]string{
+						"prometheus.auto.enable": "true",
+						"environment":            "staging",
+					},
+				},
+			},
+			labelFilter: map[string]string{
+				"environment": "production",
+			},
+			expectedCount: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			enabled := filterEnabledContainers(tt.containers, tt.labelFilter)
+			if len(enabled) != tt.expectedCount {
+				t.Errorf("expected %d containers, got %d", tt.expectedCount, len(enabled))
+			}
+		})
+	}
+}
+
+func TestDockerDiscovererDiscover(t *testing.T) {
+	mockClient := &mockDockerClient{
+		containers: []container.Summary{
+			{
+				ID:    "container1",
+				Names: []string{"/app-one"},
+				Image: "app:latest",
+				Labels: map[string]string{
+					"prometheus.auto.enable": "true",
+					"prometheus.auto.port":   "9090",
+				},
+			},
+		},
+		containerInfo: map[string]container.InspectResponse{
+			"container1": {
+				NetworkSettings: &container.NetworkSettings{
+					Networks: map[string]*network.EndpointSettings{
+						"bridge": {IPAddress: "172.17.0.2"},
+					},
+				},
+			},
+		},
+	}
+
+	d := newDockerDiscoverer(mockClient, "docker", nil)
+	targets, err := d.Discover(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(targets) != 1 {
+		t.Fatalf("expected 1 target, got %d", len(targets))
+	}
+
+	got := targets[0]
+	if got.Address != "172.17.0.2:9090" {
+		t.Errorf("expected address 172.17.0.2:9090, got %s", got.Address)
+	}
+	if got.Name != "app-one" {
+		t.Errorf("expected name app-one, got %s", got.Name)
+	}
+	if got.Source != "docker" {
+		t.Errorf("expected source docker, got %s", got.Source)
+	}
+}
+
+func TestDockerDiscovererListError(t *testing.T) {
+	mockClient := &mockDockerClient{listError: fmt.Errorf("docker API error")}
+
+	d := newDockerDiscoverer(mockClient, "docker", nil)
+	if _, err := d.Discover(context.Background()); err == nil {
+		t.Error("expected error but got none")
+	}
+}
+
+func TestMergeTargetsDeduplicatesByAddress(t *testing.T) {
+	a := &mockDockerClient{
+		containers: []container.Summary{
+			{ID: "container1", Names: []string{"/app-one"}, Labels: map[string]string{"prometheus.auto.enable": "true"}},
+		},
+		containerInfo: map[string]container.InspectResponse{
+			"container1": {
+				NetworkSettings: &container.NetworkSettings{
+					Networks: map[string]*network.EndpointSettings{"bridge": {IPAddress: "172.17.0.2"}},
+				},
+			},
+		},
+	}
+	b := &mockDockerClient{
+		containers: []container.Summary{
+			{ID: "container1-dup", Names: []string{"/app-one-dup"}, Labels: map[string]string{"prometheus.auto.enable": "true"}},
+		},
+		containerInfo: map[string]container.InspectResponse{
+			"container1-dup": {
+				NetworkSettings: &container.NetworkSettings{
+					Networks: map[string]*network.EndpointSettings{"bridge": {IPAddress: "172.17.0.2"}},
+				},
+			},
+		},
+	}
+
+	discoverers := []Discoverer{
+		newDockerDiscoverer(a, "docker", nil),
+		newDockerDiscoverer(b, "remote:second", nil),
+	}
+
+	targets, err := mergeTargets(context.Background(), discoverers)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(targets) != 1 {
+		t.Fatalf("expected duplicate address to be merged into 1 target, got %d", len(targets))
+	}
+}
+
+func TestLoadRemoteEndpointsBuildsOneDiscovererPerHost(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "endpoints.yaml")
+	contents := "endpoints:\n" +
+		"  - host: tcp://remote-a:2376\n" +
+		"  - host: tcp://remote-b:2376\n" +
+		"    tls:\n" +
+		"      insecure_skip_verify: true\n"
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatalf("failed to write endpoints file: %v", err)
+	}
+
+	discoverers, err := loadRemoteEndpoints(path, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(discoverers) != 2 {
+		t.Fatalf("expected 2 discoverers, got %d", len(discoverers))
+	}
+}
+
+func TestLoadRemoteEndpointsMissingFile(t *testing.T) {
+	if _, err := loadRemoteEndpoints("/nonexistent/endpoints.yaml", nil); err == nil {
+		t.Error("expected error for missing endpoints file, got nil")
+	}
+}
+
+func TestRemoteTLSHTTPClientHonorsInsecureSkipVerify(t *testing.T) {
+	httpClient, err := remoteTLSHTTPClient(&remoteTLSOpts{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	transport, ok := httpClient.Transport.(*http.Transport)
+	if !ok {
+		t.Fatalf("expected *http.Transport, got %T", httpClient.Transport)
+	}
+	if !transport.TLSClientConfig.InsecureSkipVerify {
+		t.Error("expected InsecureSkipVerify to be carried through to the tls.Config")
+	}
+}
+
+func writeFileSDFile(t *testing.T, entries []fileSDEntry) string {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "targets.json")
+	data, err := json.Marshal(entries)
+	if err != nil {
+		t.Fatalf("failed to marshal file SD entries: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("failed to write file SD file: %v", err)
+	}
+	return path
+}
+
+func TestNewFileDiscovererLoadsInitialTargets(t *testing.T) {
+	path := writeFileSDFile(t, []fileSDEntry{
+		{Targets: []string{"10.0.0.1:9090", "10.0.0.2:9090"}, Labels: map[string]string{"env": "prod"}},
+	})
+
+	d, err := newFileDiscoverer(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	targets, err := d.Discover(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(targets) != 2 {
+		t.Fatalf("expected 2 targets, got %d", len(targets))
+	}
+	if targets[0].Labels["env"] != "prod" {
+		t.Errorf("expected label env=prod, got %v", targets[0].Labels)
+	}
+	if targets[0].Source != "file:"+path {
+		t.Errorf("expected source file:%s, got %s", path, targets[0].Source)
+	}
+}
+
+func TestNewFileDiscovererMissingFile(t *testing.T) {
+	if _, err := newFileDiscoverer("/nonexistent/targets.json"); err == nil {
+		t.Error("expected error for missing file SD file, got nil")
+	}
+}
+
+func TestFileDiscovererReloadPicksUpChanges(t *testing.T) {
+	path := writeFileSDFile(t, []fileSDEntry{
+		{Targets: []string{"10.0.0.1:9090"}},
+	})
+
+	d, err := newFileDiscoverer(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data, err := json.Marshal([]fileSDEntry{{Targets: []string{"10.0.0.1:9090", "10.0.0.2:9090"}}})
+	if err != nil {
+		t.Fatalf("failed to marshal updated entries: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("failed to rewrite file SD file: %v", err)
+	}
+
+	if err := d.reload(); err != nil {
+		t.Fatalf("unexpected error reloading: %v", err)
+	}
+
+	targets, err := d.Discover(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(targets) != 2 {
+		t.Fatalf("expected 2 targets after reload, got %d", len(targets))
+	}
+}
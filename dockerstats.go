@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// containerStatsSample holds one container's resource usage, computed from a
+// single ContainerStats snapshot, ready to be emitted as Prometheus metrics.
+type containerStatsSample struct {
+	containerID   string
+	containerName string
+	image         string
+	extraLabels   map[string]string
+
+	cpuPercent      float64
+	memUsageBytes   float64
+	memLimitBytes   float64
+	netRxBytes      float64
+	netTxBytes      float64
+	blkioReadBytes  float64
+	blkioWriteBytes float64
+}
+
+// containerStatsMetric pairs a metric name/help with the sample field it
+// reports and the value type it should be exposed as, so emitContainerStats
+// can build each metric's Desc fresh with whatever extra labels this
+// container carries (mirroring emitMetricFamily, which does the same for
+// scraped metric families). The cumulative `_total` metrics report
+// prometheus.CounterValue rather than GaugeValue so their `# TYPE` line
+// matches what the name promises, the same way selfmetrics.go's own `_total`
+// instruments are CounterVecs rather than gauges.
+type containerStatsMetric struct {
+	name      string
+	help      string
+	valueType prometheus.ValueType
+	value     func(*containerStatsSample) float64
+}
+
+var containerStatsMetrics = []containerStatsMetric{
+	{"docker_container_cpu_usage_percent", "CPU usage of a container as a percentage of a single core, across all cores.", prometheus.GaugeValue, func(s *containerStatsSample) float64 { return s.cpuPercent }},
+	{"docker_container_mem_usage_bytes", "Memory usage of a container in bytes, matching what `docker stats` reports.", prometheus.GaugeValue, func(s *containerStatsSample) float64 { return s.memUsageBytes }},
+	{"docker_container_mem_limit_bytes", "Memory limit of a container in bytes.", prometheus.GaugeValue, func(s *containerStatsSample) float64 { return s.memLimitBytes }},
+	{"docker_container_net_rx_bytes_total", "Total bytes received over all of a container's network interfaces.", prometheus.CounterValue, func(s *containerStatsSample) float64 { return s.netRxBytes }},
+	{"docker_container_net_tx_bytes_total", "Total bytes transmitted over all of a container's network interfaces.", prometheus.CounterValue, func(s *containerStatsSample) float64 { return s.netTxBytes }},
+	{"docker_container_blkio_read_bytes_total", "Total bytes read from block devices by a container.", prometheus.CounterValue, func(s *containerStatsSample) float64 { return s.blkioReadBytes }},
+	{"docker_container_blkio_write_bytes_total", "Total bytes written to block devices by a container.", prometheus.CounterValue, func(s *containerStatsSample) float64 { return s.blkioWriteBytes }},
+}
+
+// emitContainerStats emits one container's resource usage sample, injecting
+// the same identification labels the scrape targets use plus any
+// prometheus.auto.label.* labels declared on the container.
+func emitContainerStats(ch chan<- prometheus.Metric, s *containerStatsSample) {
+	labelNames := []string{"container_id", "container_name", "image"}
+	labelValues := []string{s.containerID, s.containerName, s.image}
+	for name, value := range s.extraLabels {
+		labelNames = append(labelNames, name)
+		labelValues = append(labelValues, value)
+	}
+
+	for _, m := range containerStatsMetrics {
+		desc := prometheus.NewDesc(m.name, m.help, labelNames, nil)
+		ch <- prometheus.MustNewConstMetric(desc, m.valueType, m.value(s), labelValues...)
+	}
+}
+
+// updateContainerStats refreshes resource usage for every running container
+// that matches statsLabelFilter (empty filter means all containers),
+// independent of which containers are opted into metrics scraping via
+// prometheus.auto.enable.
+func (mc *MetricsCollector) updateContainerStats(ctx context.Context) {
+	containers, err := mc.dockerClient.ContainerList(ctx, container.ListOptions{})
+	if err != nil {
+		log.Printf("Error listing containers for stats: %v", err)
+		return
+	}
+
+	stats := make(map[string]*containerStatsSample, len(containers))
+	for _, c := range containers {
+		if !matchesLabelFilter(c.Labels, mc.statsLabelFilter) {
+			continue
+		}
+
+		sample, err := mc.collectContainerStats(ctx, c.ID)
+		if err != nil {
+			log.Printf("Error collecting stats for container %s: %v", c.ID, err)
+			continue
+		}
+
+		sample.containerID = c.ID
+		sample.containerName = containerDisplayName(c)
+		sample.image = c.Image
+		sample.extraLabels = discoveredAutoLabels(c.Labels)
+		stats[c.ID] = sample
+	}
+
+	mc.mu.Lock()
+	mc.containerStats = stats
+	mc.mu.Unlock()
+}
+
+// collectContainerStats pulls one non-streaming stats snapshot for a
+// container and reduces it to the handful of figures `docker stats` shows.
+func (mc *MetricsCollector) collectContainerStats(ctx context.Context, containerID string) (*containerStatsSample, error) {
+	reader, err := mc.dockerClient.ContainerStats(ctx, containerID, false)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Body.Close()
+
+	var raw container.StatsResponse
+	if err := json.NewDecoder(reader.Body).Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	memUsage, memLimit := containerMemoryUsage(raw)
+	rx, tx := containerNetworkTotals(raw)
+	blkioRead, blkioWrite := containerBlkioTotals(raw)
+
+	return &containerStatsSample{
+		cpuPercent:      containerCPUPercent(raw),
+		memUsageBytes:   memUsage,
+		memLimitBytes:   memLimit,
+		netRxBytes:      rx,
+		netTxBytes:      tx,
+		blkioReadBytes:  blkioRead,
+		blkioWriteBytes: blkioWrite,
+	}, nil
+}
+
+// containerCPUPercent reproduces the calculation `docker stats` uses: the
+// delta in the container's total CPU time divided by the delta in the
+// system's total CPU time, scaled by the number of cores so 100% means one
+// full core saturated.
+func containerCPUPercent(stats container.StatsResponse) float64 {
+	cpuDelta := float64(stats.CPUStats.CPUUsage.TotalUsage) - float64(stats.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(stats.CPUStats.SystemUsage) - float64(stats.PreCPUStats.SystemUsage)
+	if systemDelta <= 0 || cpuDelta <= 0 {
+		return 0
+	}
+
+	cores := len(stats.CPUStats.CPUUsage.PercpuUsage)
+	if cores == 0 {
+		cores = 1
+	}
+	return (cpuDelta / systemDelta) * float64(cores) * 100
+}
+
+// containerMemoryUsage subtracts page cache from the reported usage to match
+// what `docker stats` shows on Linux. Windows containers don't report a
+// cache figure (or cgroup-style stats at all), so their raw commit bytes are
+// used as-is.
+func containerMemoryUsage(stats container.StatsResponse) (usage, limit float64) {
+	if cache, ok := stats.MemoryStats.Stats["cache"]; ok {
+		return float64(stats.MemoryStats.Usage) - float64(cache), float64(stats.MemoryStats.Limit)
+	}
+	return float64(stats.MemoryStats.Commit), float64(stats.MemoryStats.CommitPeak)
+}
+
+func containerNetworkTotals(stats container.StatsResponse) (rx, tx float64) {
+	for _, net := range stats.Networks {
+		rx += float64(net.RxBytes)
+		tx += float64(net.TxBytes)
+	}
+	return rx, tx
+}
+
+func containerBlkioTotals(stats container.StatsResponse) (read, write float64) {
+	for _, entry := range stats.BlkioStats.IoServiceBytesRecursive {
+		switch entry.Op {
+		case "read", "Read":
+			read += float64(entry.Value)
+		case "write", "Write":
+			write += float64(entry.Value)
+		}
+	}
+	return read, write
+}
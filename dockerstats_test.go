@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestContainerCPUPercent(t *testing.T) {
+	tests := []struct {
+		name  string
+		stats container.StatsResponse
+		want  float64
+	}{
+		{
+			name: "single core fully saturated",
+			stats: container.StatsResponse{
+				CPUStats: container.CPUStats{
+					CPUUsage:    container.CPUUsage{TotalUsage: 1100, PercpuUsage: []uint64{0}},
+					SystemUsage: 2000,
+				},
+				PreCPUStats: container.CPUStats{
+					CPUUsage:    container.CPUUsage{TotalUsage: 100},
+					SystemUsage: 1000,
+				},
+			},
+			want: 100,
+		},
+		{
+			name: "two cores, half of one core used",
+			stats: container.StatsResponse{
+				CPUStats: container.CPUStats{
+					CPUUsage:    container.CPUUsage{TotalUsage: 350, PercpuUsage: []uint64{0, 0}},
+					SystemUsage: 2000,
+				},
+				PreCPUStats: container.CPUStats{
+					CPUUsage:    container.CPUUsage{TotalUsage: 100},
+					SystemUsage: 1000,
+				},
+			},
+			want: 50,
+		},
+		{
+			name: "no system delta yields zero",
+			stats: container.StatsResponse{
+				CPUStats:    container.CPUStats{CPUUsage: container.CPUUsage{TotalUsage: 200}, SystemUsage: 1000},
+				PreCPUStats: container.CPUStats{CPUUsage: container.CPUUsage{TotalUsage: 100}, SystemUsage: 1000},
+			},
+			want: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := containerCPUPercent(tt.stats); got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestContainerMemoryUsageLinuxSubtractsCache(t *testing.T) {
+	stats := container.StatsResponse{
+		MemoryStats: container.MemoryStats{
+			Usage: 1000,
+			Limit: 2000,
+			Stats: map[string]uint64{"cache": 400},
+		},
+	}
+
+	usage, limit := containerMemoryUsage(stats)
+	if usage != 600 {
+		t.Errorf("expected usage of 600 (1000-400 cache), got %v", usage)
+	}
+	if limit != 2000 {
+		t.Errorf("expected limit of 2000, got %v", limit)
+	}
+}
+
+func TestContainerMemoryUsageWindowsFallsBackToCommitBytes(t *testing.T) {
+	stats := container.StatsResponse{
+		MemoryStats: container.MemoryStats{Commit: 500, CommitPeak: 700},
+	}
+
+	usage, limit := containerMemoryUsage(stats)
+	if usage != 500 {
+		t.Errorf("expected usage of 500 commit bytes, got %v", usage)
+	}
+	if limit != 700 {
+		t.Errorf("expected limit of 700 commit peak bytes, got %v", limit)
+	}
+}
+
+func TestContainerNetworkAndBlkioTotals(t *testing.T) {
+	stats := container.StatsResponse{
+		Networks: map[string]container.NetworkStats{
+			"eth0": {RxBytes: 100, TxBytes: 50},
+			"eth1": {RxBytes: 10, TxBytes: 5},
+		},
+		BlkioStats: container.BlkioStats{
+			IoServiceBytesRecursive: []container.BlkioStatEntry{
+				{Op: "Read", Value: 300},
+				{Op: "Write", Value: 150},
+				{Op: "Read", Value: 20},
+			},
+		},
+	}
+
+	rx, tx := containerNetworkTotals(stats)
+	if rx != 110 || tx != 55 {
+		t.Errorf("expected rx=110 tx=55, got rx=%v tx=%v", rx, tx)
+	}
+
+	read, write := containerBlkioTotals(stats)
+	if read != 320 || write != 150 {
+		t.Errorf("expected read=320 write=150, got read=%v write=%v", read, write)
+	}
+}
+
+func TestUpdateContainerStatsPopulatesSamplesForRunningContainers(t *testing.T) {
+	mockClient := &mockDockerClient{
+		containers: []container.Summary{
+			{ID: "c1", Names: []string{"/app"}, Image: "app:latest", Labels: map[string]string{
+				"prometheus.auto.label.env": "production",
+			}},
+		},
+		containerStats: map[string]container.StatsResponse{
+			"c1": {
+				CPUStats:    container.CPUStats{CPUUsage: container.CPUUsage{TotalUsage: 200, PercpuUsage: []uint64{0}}, SystemUsage: 2000},
+				PreCPUStats: container.CPUStats{CPUUsage: container.CPUUsage{TotalUsage: 100}, SystemUsage: 1000},
+				MemoryStats: container.MemoryStats{Usage: 1000, Limit: 2000, Stats: map[string]uint64{"cache": 200}},
+			},
+		},
+	}
+
+	mc := &MetricsCollector{dockerClient: mockClient, statsLabelFilter: make(map[string]string)}
+	mc.updateContainerStats(context.Background())
+
+	mc.mu.RLock()
+	sample, ok := mc.containerStats["c1"]
+	mc.mu.RUnlock()
+
+	if !ok {
+		t.Fatal("expected a stats sample to be recorded for c1")
+	}
+	if sample.containerName != "app" {
+		t.Errorf("expected container name app, got %s", sample.containerName)
+	}
+	if sample.memUsageBytes != 800 {
+		t.Errorf("expected mem usage of 800, got %v", sample.memUsageBytes)
+	}
+	if sample.extraLabels["env"] != "production" {
+		t.Errorf("expected extra label env=production, got %v", sample.extraLabels)
+	}
+}
+
+func TestEmitContainerStatsReportsCumulativeMetricsAsCounters(t *testing.T) {
+	s := &containerStatsSample{containerID: "c1", containerName: "app", image: "app:latest"}
+
+	ch := make(chan prometheus.Metric, len(containerStatsMetrics))
+	emitContainerStats(ch, s)
+	close(ch)
+
+	for m := range ch {
+		var out dto.Metric
+		if err := m.Write(&out); err != nil {
+			t.Fatalf("unexpected error writing metric: %v", err)
+		}
+
+		name := m.Desc().String()
+		isTotal := strings.Contains(name, "_total")
+		if isTotal && out.Counter == nil {
+			t.Errorf("expected %s to be reported as a counter, got %+v", name, out)
+		}
+		if !isTotal && out.Gauge == nil {
+			t.Errorf("expected %s to be reported as a gauge, got %+v", name, out)
+		}
+	}
+}
+
+func TestUpdateContainerStatsHonorsStatsLabelFilter(t *testing.T) {
+	mockClient := &mockDockerClient{
+		containers: []container.Summary{
+			{ID: "c1", Labels: map[string]string{"team": "payments"}},
+			{ID: "c2", Labels: map[string]string{"team": "search"}},
+		},
+		containerStats: map[string]container.StatsResponse{
+			"c1": {}, "c2": {},
+		},
+	}
+
+	mc := &MetricsCollector{dockerClient: mockClient, statsLabelFilter: map[string]string{"team": "payments"}}
+	mc.updateContainerStats(context.Background())
+
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+	if _, ok := mc.containerStats["c1"]; !ok {
+		t.Error("expected c1 to match the stats label filter")
+	}
+	if _, ok := mc.containerStats["c2"]; ok {
+		t.Error("expected c2 to be excluded by the stats label filter")
+	}
+}
@@ -2,317 +2,789 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"mime"
+	"net"
 	"net/http"
 	"os"
-	"regexp"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/swarm"
 	"github.com/docker/docker/client"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
 )
 
 type DockerClient interface {
 	ContainerList(ctx context.Context, options container.ListOptions) ([]container.Summary, error)
 	ContainerInspect(ctx context.Context, containerID string) (container.InspectResponse, error)
+	ContainerStats(ctx context.Context, containerID string, stream bool) (container.StatsResponseReader, error)
+	ServiceList(ctx context.Context, options types.ServiceListOptions) ([]swarm.Service, error)
+	TaskList(ctx context.Context, options types.TaskListOptions) ([]swarm.Task, error)
+	NodeList(ctx context.Context, options types.NodeListOptions) ([]swarm.Node, error)
+}
+
+// containerTarget holds the last scrape result for a single labeled container,
+// already parsed into Prometheus metric families so it can be re-emitted with
+// injected identification labels without colliding with other containers.
+type containerTarget struct {
+	containerID    string
+	containerName  string
+	image          string
+	extraLabels    map[string]string
+	labels         map[string]string
+	families       map[string]*dto.MetricFamily
+	scrapeDuration float64
+	scrapeSuccess  bool
+	samplesScraped int
+	lastScrape     time.Time
+	lastErr        error
+	warnings       []string
+	source         string
 }
 
 type MetricsCollector struct {
-	dockerClient DockerClient
-	mu           sync.RWMutex
-	metricsCache map[string]string
-	labelFilter  map[string]string
+	dockerClient     DockerClient
+	mu               sync.RWMutex
+	labelFilter      map[string]string
+	targets          map[string]*containerTarget
+	currentTargets   map[string]Target
+	schedules        map[string]*targetSchedule
+	containerStats   map[string]*containerStatsSample
+	statsLabelFilter map[string]string
+	scrapeHistory    map[string][]ScrapeResult
+	discoverers      []Discoverer
+	sdTargets        []HTTPSDTarget
+	relabelRules     []*compiledRelabelConfig
+	scrapeClients    map[string]*cachedScrapeClient
+	SwarmMode        bool
+	remoteWrite      *remoteWriteClient
+	scrapeSemaphore  chan struct{}
+	defaultInterval  time.Duration
+	defaultTimeout   time.Duration
+	clock            clock
+	StaleAfter       time.Duration
+
+	scrapeErrorsTotal prometheus.Counter
+	selfMetrics       *selfMetrics
 }
 
+var (
+	scrapeDurationDesc = prometheus.NewDesc(
+		"docker_collector_scrape_duration_seconds",
+		"Duration in seconds of the last scrape of a container target.",
+		[]string{"container"}, nil,
+	)
+	scrapeSuccessDesc = prometheus.NewDesc(
+		"docker_collector_scrape_success",
+		"Whether the last scrape of a container target succeeded (1) or failed (0).",
+		[]string{"container"}, nil,
+	)
+	scrapeSamplesDesc = prometheus.NewDesc(
+		"docker_collector_scrape_samples_scraped",
+		"Number of samples scraped from a container target during the last scrape.",
+		[]string{"container"}, nil,
+	)
+	// upDesc and scrapeDurationSecondsDesc use Prometheus's own conventional
+	// meta-metric names (as a real Prometheus server emits for every scrape
+	// target) alongside the docker_collector_-prefixed equivalents above, so
+	// dashboards written against either convention work unmodified.
+	upDesc = prometheus.NewDesc(
+		"up",
+		"1 if the last scrape of this target succeeded, 0 otherwise.",
+		[]string{"container"}, nil,
+	)
+	scrapeDurationSecondsDesc = prometheus.NewDesc(
+		"scrape_duration_seconds",
+		"Duration in seconds of the last scrape of this target.",
+		[]string{"container"}, nil,
+	)
+	targetLastScrapeDesc = prometheus.NewDesc(
+		"docker_collector_target_last_scrape_seconds",
+		"Unix timestamp of the last scrape attempt of a container target.",
+		[]string{"container"}, nil,
+	)
+)
+
 func NewMetricsCollector() (*MetricsCollector, error) {
 	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Docker client: %w", err)
 	}
 
-	labelFilter := make(map[string]string)
-	if filterEnv := os.Getenv("PROMETHEUS_LABEL_FILTER"); filterEnv != "" {
-		pairs := strings.Split(filterEnv, ",")
-		for _, pair := range pairs {
-			parts := strings.SplitN(pair, "=", 2)
-			if len(parts) == 2 {
-				labelFilter[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
-			}
-		}
+	labelFilter := parseLabelFilterEnv("PROMETHEUS_LABEL_FILTER")
+	if len(labelFilter) > 0 {
 		log.Printf("Using label filter: %v", labelFilter)
 	}
 
+	statsLabelFilter := parseLabelFilterEnv("PROMETHEUS_DOCKER_STATS_LABEL_FILTER")
+
+	// PROMETHEUS_LABEL_FILTER is translated into "keep" relabel rules up
+	// front, so the legacy env var and PROMETHEUS_RELABEL_CONFIG both feed
+	// the same mechanism instead of two independent filtering paths.
+	rawRelabelConfigs := labelFilterRelabelConfigs(labelFilter)
+	if relabelConfigPath := os.Getenv("PROMETHEUS_RELABEL_CONFIG"); relabelConfigPath != "" {
+		fileConfigs, err := loadRelabelConfigFile(relabelConfigPath)
+		if err != nil {
+			return nil, err
+		}
+		rawRelabelConfigs = append(rawRelabelConfigs, fileConfigs...)
+	}
+	relabelRules, err := compileRelabelConfigs(rawRelabelConfigs)
+	if err != nil {
+		return nil, err
+	}
+
 	return &MetricsCollector{
-		dockerClient: cli,
-		metricsCache: make(map[string]string),
-		labelFilter:  labelFilter,
+		dockerClient:     cli,
+		labelFilter:      labelFilter,
+		statsLabelFilter: statsLabelFilter,
+		targets:          make(map[string]*containerTarget),
+		schedules:        make(map[string]*targetSchedule),
+		relabelRules:     relabelRules,
+		scrapeSemaphore:  make(chan struct{}, scrapeConcurrency()),
+		defaultInterval:  scrapeIntervalEnv(),
+		defaultTimeout:   defaultScrapeTimeout,
+		clock:            realClock{},
+		StaleAfter:       staleAfterEnv(),
+		scrapeErrorsTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "docker_collector_scrape_errors_total",
+			Help: "Total number of scrape errors across all containers.",
+		}),
+		selfMetrics: newSelfMetrics(),
 	}, nil
 }
 
-func (mc *MetricsCollector) discoverContainers(ctx context.Context) ([]container.Summary, error) {
-	containers, err := mc.dockerClient.ContainerList(ctx, container.ListOptions{})
-	if err != nil {
-		return nil, fmt.Errorf("failed to list containers: %w", err)
+// parseLabelFilterEnv parses a comma-separated key=value env var into a
+// label filter map, the format shared by PROMETHEUS_LABEL_FILTER and
+// PROMETHEUS_DOCKER_STATS_LABEL_FILTER.
+func parseLabelFilterEnv(name string) map[string]string {
+	filter := make(map[string]string)
+	raw := os.Getenv(name)
+	if raw == "" {
+		return filter
+	}
+	for _, pair := range strings.Split(raw, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) == 2 {
+			filter[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+		}
 	}
+	return filter
+}
 
-	var prometheusContainers []container.Summary
-	for _, container := range containers {
-		if container.Labels["prometheus.auto.enable"] != "true" {
-			continue
+// scrapeIntervalEnv resolves the collector-wide default scrape interval from
+// PROMETHEUS_SCRAPE_INTERVAL, falling back to defaultScrapeInterval. Per-target
+// prometheus.auto.interval labels take precedence over this default.
+func scrapeIntervalEnv() time.Duration {
+	if raw := os.Getenv("PROMETHEUS_SCRAPE_INTERVAL"); raw != "" {
+		if d, err := time.ParseDuration(raw); err == nil && d > 0 {
+			return d
 		}
+	}
+	return defaultScrapeInterval
+}
 
-		// Check if container matches all label filters
-		if len(mc.labelFilter) > 0 {
-			matches := true
-			for filterKey, filterValue := range mc.labelFilter {
-				if labelValue, ok := container.Labels[filterKey]; !ok || labelValue != filterValue {
-					matches = false
-					break
-				}
-			}
-			if !matches {
-				continue
-			}
+// staleAfterEnv resolves the collector-wide StaleAfter threshold from
+// PROMETHEUS_STALE_AFTER. It defaults to 0 (disabled): operators opt into
+// evicting stale cached entries and SD targets explicitly, the same way
+// swarm discovery is gated behind PROMETHEUS_SWARM_MODE.
+func staleAfterEnv() time.Duration {
+	if raw := os.Getenv("PROMETHEUS_STALE_AFTER"); raw != "" {
+		if d, err := time.ParseDuration(raw); err == nil && d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// Describe intentionally sends nothing, making this an unchecked collector:
+// the set of metric families depends on whatever each container exposes and
+// cannot be known ahead of a scrape.
+func (mc *MetricsCollector) Describe(ch chan<- *prometheus.Desc) {}
+
+func (mc *MetricsCollector) Collect(ch chan<- prometheus.Metric) {
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+
+	for _, target := range mc.targets {
+		for _, mf := range target.families {
+			emitMetricFamily(ch, target, mf)
+		}
+
+		ch <- prometheus.MustNewConstMetric(scrapeDurationDesc, prometheus.GaugeValue, target.scrapeDuration, target.containerName)
+		successValue := 0.0
+		if target.scrapeSuccess {
+			successValue = 1.0
+		}
+		ch <- prometheus.MustNewConstMetric(scrapeSuccessDesc, prometheus.GaugeValue, successValue, target.containerName)
+		ch <- prometheus.MustNewConstMetric(scrapeSamplesDesc, prometheus.GaugeValue, float64(target.samplesScraped), target.containerName)
+		ch <- prometheus.MustNewConstMetric(upDesc, prometheus.GaugeValue, successValue, target.containerName)
+		ch <- prometheus.MustNewConstMetric(scrapeDurationSecondsDesc, prometheus.GaugeValue, target.scrapeDuration, target.containerName)
+		ch <- prometheus.MustNewConstMetric(targetLastScrapeDesc, prometheus.GaugeValue, float64(target.lastScrape.Unix()), target.containerName)
+	}
+
+	for _, stats := range mc.containerStats {
+		emitContainerStats(ch, stats)
+	}
+
+	ch <- mc.scrapeErrorsTotal
+	if mc.selfMetrics != nil {
+		mc.selfMetrics.collect(ch)
+	}
+}
+
+// emitMetricFamily re-encodes a single parsed metric family, injecting the
+// container's identification labels (and any configured extra labels) into
+// every sample so that otherwise identically-named metrics from different
+// containers don't collide.
+func emitMetricFamily(ch chan<- prometheus.Metric, target *containerTarget, mf *dto.MetricFamily) {
+	baseLabelNames := []string{"container_id", "container_name", "image"}
+	for name := range target.extraLabels {
+		baseLabelNames = append(baseLabelNames, name)
+	}
+
+	for _, m := range mf.GetMetric() {
+		labelNames := append([]string{}, baseLabelNames...)
+		labelValues := []string{target.containerID, target.containerName, target.image}
+		for _, name := range baseLabelNames[3:] {
+			labelValues = append(labelValues, target.extraLabels[name])
+		}
+		for _, lp := range m.GetLabel() {
+			labelNames = append(labelNames, lp.GetName())
+			labelValues = append(labelValues, lp.GetValue())
 		}
 
-		prometheusContainers = append(prometheusContainers, container)
+		desc := prometheus.NewDesc(mf.GetName(), mf.GetHelp(), labelNames, nil)
+		ch <- &rawMetric{desc: desc, metric: m, labels: zipLabelPairs(labelNames, labelValues)}
 	}
+}
+
+// zipLabelPairs pairs parallel label name/value slices into the
+// []*dto.LabelPair form dto.Metric.Label expects.
+func zipLabelPairs(names, values []string) []*dto.LabelPair {
+	pairs := make([]*dto.LabelPair, len(names))
+	for i, name := range names {
+		n, v := name, values[i]
+		pairs[i] = &dto.LabelPair{Name: &n, Value: &v}
+	}
+	return pairs
+}
+
+// rawMetric re-emits an already-parsed dto.Metric almost verbatim behind a
+// relabeled Desc, rather than re-deriving it through MustNewConstMetric and
+// friends. That re-derivation can only carry a bare value/bucket/quantile
+// set, so anything client_golang's const-metric constructors don't expose a
+// parameter for - exemplars, OpenMetrics _created timestamps - would
+// otherwise be silently dropped on every re-emission even though it was
+// right there in the parsed response.
+type rawMetric struct {
+	desc   *prometheus.Desc
+	metric *dto.Metric
+	labels []*dto.LabelPair
+}
 
-	return prometheusContainers, nil
+func (m *rawMetric) Desc() *prometheus.Desc { return m.desc }
+
+func (m *rawMetric) Write(out *dto.Metric) error {
+	out.Label = m.labels
+	out.Counter = m.metric.Counter
+	out.Gauge = m.metric.Gauge
+	out.Summary = m.metric.Summary
+	out.Histogram = m.metric.Histogram
+	out.Untyped = m.metric.Untyped
+	out.TimestampMs = m.metric.TimestampMs
+	return nil
+}
+
+const (
+	acceptOpenMetrics = "application/openmetrics-text; version=1.0.0"
+	acceptText        = "text/plain; version=0.0.4"
+	acceptNegotiated  = acceptOpenMetrics + ",text/plain;version=0.0.4;q=0.5,*/*;q=0.1"
+)
+
+// scrapeAcceptHeader builds the Accept header sent to a target, honoring a
+// per-container `prometheus.auto.format` override. With no override, both
+// formats are offered and the target picks via content negotiation, just as
+// a real Prometheus server would.
+func scrapeAcceptHeader(formatOverride string) string {
+	switch formatOverride {
+	case "openmetrics":
+		return acceptOpenMetrics
+	case "prometheus":
+		return acceptText
+	default:
+		return acceptNegotiated
+	}
 }
 
 func (mc *MetricsCollector) fetchMetrics(ctx context.Context, ip string, port string) (string, error) {
-	url := fmt.Sprintf("http://%s:%s/metrics", ip, port)
+	body, _, err := mc.fetchMetricsNegotiated(ctx, Target{Address: net.JoinHostPort(ip, port)}, ip, port, "")
+	return body, err
+}
+
+// fetchMetricsNegotiated scrapes a target, negotiating the exposition format
+// via the Accept header and returning the format the target actually replied
+// with so the caller can parse the body accordingly. The scheme, TLS
+// settings and any basic-auth/bearer-token credentials are all driven by the
+// target's prometheus.auto.* labels, resolved through a cached per-container
+// *http.Client so PEM/secret files aren't re-read on every scrape.
+func (mc *MetricsCollector) fetchMetricsNegotiated(ctx context.Context, tgt Target, ip string, port string, formatOverride string) (string, expfmt.Format, error) {
+	scheme := scrapeSchemeFor(tgt.Labels)
+	url := fmt.Sprintf("%s://%s:%s/metrics", scheme, ip, port)
 
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+		return "", "", &scrapeError{category: scrapeResultConnectionError, err: fmt.Errorf("failed to create request: %w", err)}
+	}
+	req.Header.Set("Accept", scrapeAcceptHeader(formatOverride))
+
+	client, auth, err := mc.scrapeClientFor(tgt)
+	if err != nil {
+		return "", "", &scrapeError{category: scrapeResultConnectionError, err: fmt.Errorf("failed to build scrape client for %s: %w", url, err)}
+	}
+	if auth.basicAuthUsername != "" {
+		req.SetBasicAuth(auth.basicAuthUsername, auth.basicAuthPassword)
+	}
+	if auth.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+auth.bearerToken)
 	}
 
-	client := &http.Client{Timeout: 5 * time.Second}
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("failed to fetch metrics from %s: %w", url, err)
+		category := scrapeResultConnectionError
+		if errors.Is(err, context.DeadlineExceeded) {
+			category = scrapeResultTimeout
+		}
+		return "", "", &scrapeError{category: category, err: fmt.Errorf("failed to fetch metrics from %s: %w", url, err)}
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("unexpected status code %d from %s", resp.StatusCode, url)
+		return "", "", &scrapeError{category: scrapeResultHTTPError, err: fmt.Errorf("unexpected status code %d from %s", resp.StatusCode, url)}
 	}
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("failed to read response body: %w", err)
+		return "", "", &scrapeError{category: scrapeResultConnectionError, err: fmt.Errorf("failed to read response body: %w", err)}
 	}
 
-	return string(body), nil
+	return string(body), detectResponseFormat(resp.Header), nil
 }
 
-func (mc *MetricsCollector) updateMetrics(ctx context.Context) {
-	containers, err := mc.discoverContainers(ctx)
+// detectResponseFormat determines the exposition format of a scrape response.
+// expfmt.ResponseFormat only ever distinguishes FmtProtoDelim from FmtText -
+// it has no OpenMetrics case and returns FmtUnknown for a
+// Content-Type: application/openmetrics-text reply - so the media type is
+// parsed directly first to catch that case before falling back to it.
+func detectResponseFormat(header http.Header) expfmt.Format {
+	if mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type")); err == nil && mediaType == "application/openmetrics-text" {
+		return expfmt.FmtOpenMetrics_1_0_0
+	}
+	return expfmt.ResponseFormat(header)
+}
+
+// containerExtraLabels resolves the `prometheus.auto.labels=k1,k2` annotation
+// into a map of label name to the container label's value, so operators can
+// opt specific container labels into becoming metric labels. swarm_* labels
+// are always included when present, the same way swarmDiscoverer's task
+// identity isn't gated behind an opt-in annotation.
+func containerExtraLabels(labels map[string]string) map[string]string {
+	extra := swarmLabels(labels)
+	declared := labels["prometheus.auto.labels"]
+	if declared == "" {
+		return extra
+	}
+	for _, key := range strings.Split(declared, ",") {
+		key = strings.TrimSpace(key)
+		if key == "" {
+			continue
+		}
+		extra[key] = labels[key]
+	}
+	return extra
+}
+
+// activeDiscoverers returns the configured Discoverer set, falling back to
+// plain single-daemon Docker discovery (the collector's original behavior)
+// when none were explicitly composed in main.
+func (mc *MetricsCollector) activeDiscoverers() []Discoverer {
+	if len(mc.discoverers) > 0 {
+		return mc.discoverers
+	}
+	discoverers := []Discoverer{newDockerDiscoverer(mc.dockerClient, "docker", mc.labelFilter)}
+	if mc.SwarmMode {
+		discoverers = append(discoverers, newSwarmDiscoverer(mc.dockerClient, mc.labelFilter))
+	}
+	return discoverers
+}
+
+// isStale reports whether a target's last scrape is old enough that its
+// cached metric families and SD entry should be dropped even though the
+// container is still being discovered, e.g. because it's stuck failing or
+// hanging well past its schedule. A target that has never been scraped yet
+// (the zero time) is never considered stale, and the check is a no-op when
+// StaleAfter is left at its default of 0 (disabled).
+func (mc *MetricsCollector) isStale(now time.Time, lastScrape time.Time) bool {
+	if mc.StaleAfter <= 0 || lastScrape.IsZero() {
+		return false
+	}
+	return now.Sub(lastScrape) > mc.StaleAfter
+}
+
+// refreshTargets re-runs service discovery, applies the relabel rules to
+// decide which targets survive and under what address/labels, and
+// reconciles the scheduler state: new targets get a schedule with a
+// jittered first scrape, targets that disappeared lose their schedule (and
+// cached result) entirely. It does not itself scrape anything; runDueScrapes
+// and updateMetrics do that.
+func (mc *MetricsCollector) refreshTargets(ctx context.Context) {
+	targets, err := mergeTargets(ctx, mc.activeDiscoverers())
 	if err != nil {
-		log.Printf("Error discovering containers: %v", err)
+		log.Printf("Error discovering targets: %v", err)
 		return
 	}
 
-	newMetrics := make(map[string]string)
-	var wg sync.WaitGroup
+	now := mc.now()
 
-	for _, c := range containers {
-		wg.Add(1)
-		go func(c container.Summary) {
-			defer wg.Done()
+	mc.mu.RLock()
+	lastScrapeByID := make(map[string]time.Time, len(mc.targets))
+	for id, tgt := range mc.targets {
+		lastScrapeByID[id] = tgt.lastScrape
+	}
+	mc.mu.RUnlock()
 
-			port := c.Labels["prometheus.auto.port"]
-			if port == "" {
-				port = "80"
-			}
+	current := make(map[string]Target, len(targets))
+	newSDTargets := make([]HTTPSDTarget, 0, len(targets))
 
-			containerInfo, err := mc.dockerClient.ContainerInspect(ctx, c.ID)
-			if err != nil {
-				log.Printf("Error inspecting container %s: %v", c.ID, err)
-				return
-			}
+	for _, tgt := range targets {
+		relabeled := applyRelabel(mc.relabelRules, metaLabels(tgt))
+		if !relabeled.keep {
+			continue
+		}
+		tgt.Address = relabeled.labels["__address__"]
+		tgt.Labels = mergeNonMetaLabels(tgt.Labels, relabeled.labels)
 
-			var containerIP string
-			for _, network := range containerInfo.NetworkSettings.Networks {
-				if network.IPAddress != "" {
-					containerIP = network.IPAddress
-					break
-				}
-			}
+		current[tgt.ID] = tgt
+		if mc.isStale(now, lastScrapeByID[tgt.ID]) {
+			continue
+		}
 
-			if containerIP == "" {
-				log.Printf("No IP address found for container %s", c.ID)
-				return
-			}
+		sdLabels := discoveredAutoLabels(tgt.Labels)
+		for k, v := range swarmLabels(tgt.Labels) {
+			sdLabels[k] = v
+		}
+		newSDTargets = append(newSDTargets, HTTPSDTarget{
+			Targets: []string{tgt.Address},
+			Labels:  sdLabels,
+		})
+	}
 
-			metrics, err := mc.fetchMetrics(ctx, containerIP, port)
-			if err != nil {
-				log.Printf("Error fetching metrics from container %s: %v", c.ID, err)
-				return
-			}
+	mc.mu.Lock()
+	if mc.schedules == nil {
+		mc.schedules = make(map[string]*targetSchedule)
+	}
+	if mc.targets == nil {
+		mc.targets = make(map[string]*containerTarget)
+	}
+	for id, tgt := range current {
+		if _, exists := mc.schedules[id]; exists {
+			continue
+		}
+		interval := scrapeIntervalFor(tgt.Labels, mc.defaultInterval)
+		timeout := scrapeTimeoutFor(tgt.Labels, mc.defaultTimeout)
+		mc.schedules[id] = newTargetSchedule(interval, timeout, now, jitterFor(id, interval))
+	}
+	for id := range mc.schedules {
+		if _, ok := current[id]; !ok {
+			delete(mc.schedules, id)
+			delete(mc.targets, id)
+			delete(mc.scrapeClients, id)
+		}
+	}
+	for id := range current {
+		if tgt, ok := mc.targets[id]; ok && mc.isStale(now, tgt.lastScrape) {
+			delete(mc.targets, id)
+		}
+	}
+	mc.currentTargets = current
+	mc.sdTargets = newSDTargets
+	cacheEntries := len(mc.targets)
+	mc.mu.Unlock()
 
-			// Apply metric filtering if specified
-			if dropMetrics := c.Labels["prometheus.auto.metrics.drop"]; dropMetrics != "" {
-				dropList := strings.Split(dropMetrics, ",")
-				for i := range dropList {
-					dropList[i] = strings.TrimSpace(dropList[i])
-				}
-				metrics = filterMetrics(metrics, dropList)
-			}
+	sm := mc.ensureSelfMetrics()
+	sm.discoveredContainers.Set(float64(len(current)))
+	sm.cacheEntries.Set(float64(cacheEntries))
+	sm.lastUpdateTimestamp.Set(float64(now.Unix()))
+}
 
-			mc.mu.Lock()
-			newMetrics[c.ID] = metrics
-			mc.mu.Unlock()
-		}(c)
+// ensureSelfMetrics lazily initializes the self-observability instruments,
+// so collectors built directly as struct literals (as the tests do) behave
+// the same as ones built through NewMetricsCollector.
+func (mc *MetricsCollector) ensureSelfMetrics() *selfMetrics {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if mc.selfMetrics == nil {
+		mc.selfMetrics = newSelfMetrics()
 	}
+	return mc.selfMetrics
+}
 
-	wg.Wait()
+// scrapeTarget scrapes a single target within its configured timeout,
+// records the result onto the collector (and the scrape history/remote
+// write queue), and updates the target's schedule for the next due time,
+// applying the circuit breaker backoff on failure.
+func (mc *MetricsCollector) scrapeTarget(ctx context.Context, tgt Target) {
+	mc.mu.RLock()
+	sched := mc.schedules[tgt.ID]
+	mc.mu.RUnlock()
+
+	timeout := mc.defaultTimeout
+	if sched != nil {
+		timeout = sched.timeout
+	}
+	if timeout <= 0 {
+		timeout = defaultScrapeTimeout
+	}
+
+	scrapeCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	target := &containerTarget{
+		containerID:   tgt.ID,
+		containerName: tgt.Name,
+		image:         tgt.Image,
+		extraLabels:   containerExtraLabels(tgt.Labels),
+		labels:        tgt.Labels,
+		source:        tgt.Source,
+		families:      make(map[string]*dto.MetricFamily),
+	}
+
+	start := mc.now()
+	err := mc.performScrape(scrapeCtx, tgt, target)
+	duration := mc.now().Sub(start)
+
+	target.scrapeDuration = duration.Seconds()
+	target.lastScrape = start
+	target.lastErr = err
+	target.scrapeSuccess = err == nil
+	if err != nil {
+		mc.scrapeErrorsTotal.Inc()
+	}
+	sm := mc.ensureSelfMetrics()
+	sm.scrapesTotal.WithLabelValues(tgt.Name, classifyScrapeError(err)).Inc()
+	sm.scrapeDurationHistogram.WithLabelValues(tgt.Name).Observe(duration.Seconds())
 
 	mc.mu.Lock()
-	mc.metricsCache = newMetrics
+	if mc.targets == nil {
+		mc.targets = make(map[string]*containerTarget)
+	}
+	mc.targets[tgt.ID] = target
+	if sched := mc.schedules[tgt.ID]; sched != nil {
+		if err != nil {
+			sched.recordFailure(mc.now(), duration)
+		} else {
+			sched.recordSuccess(mc.now(), duration)
+		}
+	}
+	duplicateWarnings := detectCrossContainerWarnings(mc.targets)
+	target.warnings = append(target.warnings, duplicateWarnings[tgt.ID]...)
 	mc.mu.Unlock()
+
+	mc.recordScrapeResult(tgt.ID, ScrapeResult{
+		Metrics:     target.families,
+		Warnings:    target.warnings,
+		Err:         target.lastErr,
+		Duration:    duration,
+		SampleCount: target.samplesScraped,
+		Timestamp:   start,
+	})
+
+	if mc.remoteWrite != nil && target.scrapeSuccess {
+		mc.remoteWrite.enqueue(seriesFromTarget(target, start.UnixMilli()))
+	}
 }
 
-func filterMetrics(metrics string, dropList []string) string {
-	if len(dropList) == 0 {
-		return metrics
+// performScrape fetches and parses a target's metrics into target.families,
+// applying any per-container prometheus.auto.metrics.drop rules alongside
+// the globally configured relabel rules. It returns the error that should be
+// recorded against the target, if any; target.families is only populated on
+// success.
+func (mc *MetricsCollector) performScrape(ctx context.Context, tgt Target, target *containerTarget) error {
+	ip, port, err := net.SplitHostPort(tgt.Address)
+	if err != nil {
+		log.Printf("Invalid target address %q: %v", tgt.Address, err)
+		return fmt.Errorf("invalid target address: %w", err)
+	}
+
+	body, format, err := mc.fetchMetricsNegotiated(ctx, tgt, ip, port, tgt.Labels["prometheus.auto.format"])
+	if err != nil {
+		log.Printf("Error fetching metrics from target %s: %v", tgt.Address, err)
+		return err
 	}
 
-	// Compile regex patterns
-	var patterns []*regexp.Regexp
-	var exactMatches []string
+	target.warnings = append(target.warnings, validateBodyEncoding(body)...)
 
-	for _, drop := range dropList {
-		// Check if it looks like a regex pattern (contains regex metacharacters)
-		if strings.ContainsAny(drop, ".*+?^$[]{}()|\\") {
-			pattern, err := regexp.Compile(drop)
-			if err != nil {
-				log.Printf("Invalid regex pattern '%s': %v, treating as exact match", drop, err)
-				exactMatches = append(exactMatches, drop)
-			} else {
-				patterns = append(patterns, pattern)
-			}
+	families, err := decodeMetricFamilies(body, format)
+	if err != nil {
+		log.Printf("Error parsing metrics from target %s: %v", tgt.Address, err)
+		return &scrapeError{category: scrapeResultParseError, err: err}
+	}
+
+	// prometheus.auto.metrics.drop and prometheus.auto.relabel are both
+	// translated into extra rules layered on top of the globally configured
+	// relabel rules, so every source of metric filtering/rewriting goes
+	// through the same relabel.go pipeline.
+	sampleRules := mc.relabelRules
+	if dropMetrics := tgt.Labels["prometheus.auto.metrics.drop"]; dropMetrics != "" {
+		dropList := strings.Split(dropMetrics, ",")
+		for i := range dropList {
+			dropList[i] = strings.TrimSpace(dropList[i])
+		}
+		containerRules, err := compileRelabelConfigs(metricsDropRelabelConfigs(dropList))
+		if err != nil {
+			log.Printf("Invalid prometheus.auto.metrics.drop on target %s: %v", tgt.Address, err)
+		} else {
+			sampleRules = append(append([]*compiledRelabelConfig{}, sampleRules...), containerRules...)
+		}
+	}
+	if relabelBlob := tgt.Labels["prometheus.auto.relabel"]; relabelBlob != "" {
+		cfgs, err := parseContainerRelabelConfigs(relabelBlob)
+		if err != nil {
+			log.Printf("Invalid prometheus.auto.relabel on target %s: %v", tgt.Address, err)
+		} else if containerRules, err := compileRelabelConfigs(cfgs); err != nil {
+			log.Printf("Invalid prometheus.auto.relabel on target %s: %v", tgt.Address, err)
 		} else {
-			exactMatches = append(exactMatches, drop)
+			sampleRules = append(append([]*compiledRelabelConfig{}, sampleRules...), containerRules...)
 		}
 	}
+	// Fast path: skip the per-metric relabel pass entirely when no rules are
+	// configured, so the common case stays a cheap decode-and-forward.
+	if len(sampleRules) > 0 {
+		families = applyRelabelToFamilies(sampleRules, families)
+	}
 
-	lines := strings.Split(metrics, "\n")
-	var filtered []string
-	var currentMetric string
-	skipMetric := false
+	samples := 0
+	for _, mf := range families {
+		samples += len(mf.GetMetric())
+	}
 
-	for _, line := range lines {
-		trimmed := strings.TrimSpace(line)
+	target.families = families
+	target.samplesScraped = samples
+	return nil
+}
 
-		// Skip empty lines
-		if trimmed == "" {
-			if !skipMetric {
-				filtered = append(filtered, line)
-			}
+// runDueScrapes scrapes every currently known target whose schedule says
+// it's due, bounded by the collector's worker pool so a large fleet of
+// containers can never spin up more than scrapeConcurrency scrapes at once.
+// Targets already mid-scrape are skipped; they'll be picked up again once
+// their in-flight scrape completes and its schedule is updated.
+func (mc *MetricsCollector) runDueScrapes(ctx context.Context) {
+	now := mc.now()
+
+	mc.mu.Lock()
+	var due []Target
+	for id, sched := range mc.schedules {
+		if sched.inFlight || sched.nextScrape.After(now) {
 			continue
 		}
-
-		// Check if it's a comment line
-		if strings.HasPrefix(trimmed, "#") {
-			// Extract metric name from HELP or TYPE comments
-			if strings.HasPrefix(trimmed, "# HELP") || strings.HasPrefix(trimmed, "# TYPE") {
-				parts := strings.Fields(trimmed)
-				if len(parts) >= 3 {
-					currentMetric = parts[2]
-					skipMetric = false
-
-					// Check exact matches
-					for _, exact := range exactMatches {
-						if currentMetric == exact {
-							skipMetric = true
-							break
-						}
-					}
-
-					// Check regex patterns
-					if !skipMetric {
-						for _, pattern := range patterns {
-							if pattern.MatchString(currentMetric) {
-								skipMetric = true
-								break
-							}
-						}
-					}
-				}
-			}
-			if !skipMetric {
-				filtered = append(filtered, line)
-			}
-		} else {
-			// It's a metric line
-			if !skipMetric {
-				// Extract metric name from the line (everything before the first space or {)
-				metricName := trimmed
-				if idx := strings.IndexAny(trimmed, " {"); idx != -1 {
-					metricName = trimmed[:idx]
-				}
-
-				// Check exact matches
-				for _, exact := range exactMatches {
-					if metricName == exact {
-						skipMetric = true
-						break
-					}
-				}
-
-				// Check regex patterns
-				if !skipMetric {
-					for _, pattern := range patterns {
-						if pattern.MatchString(metricName) {
-							skipMetric = true
-							break
-						}
-					}
-				}
-
-				if !skipMetric {
-					filtered = append(filtered, line)
-				}
-			}
+		tgt, ok := mc.currentTargets[id]
+		if !ok {
+			continue
 		}
+		sched.inFlight = true
+		due = append(due, tgt)
 	}
+	mc.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, tgt := range due {
+		mc.scrapeSemaphore <- struct{}{}
+		wg.Add(1)
+		go func(tgt Target) {
+			defer wg.Done()
+			defer func() { <-mc.scrapeSemaphore }()
+			mc.scrapeTarget(ctx, tgt)
 
-	return strings.Join(filtered, "\n")
+			mc.mu.Lock()
+			if sched := mc.schedules[tgt.ID]; sched != nil {
+				sched.inFlight = false
+			}
+			mc.mu.Unlock()
+		}(tgt)
+	}
+	wg.Wait()
 }
 
-func (mc *MetricsCollector) aggregateMetrics() string {
+// updateMetrics performs one full, synchronous discovery-and-scrape cycle:
+// it refreshes the target list and immediately scrapes every surviving
+// target, regardless of where it stands in its schedule. This is what the
+// collector does on startup (so /metrics isn't empty until the first
+// scheduler tick) and is the entry point tests drive directly; the ticking
+// runDueScrapes is what takes over afterwards for the interval/backoff-aware
+// steady state.
+func (mc *MetricsCollector) updateMetrics(ctx context.Context) {
+	mc.refreshTargets(ctx)
+
 	mc.mu.RLock()
-	defer mc.mu.RUnlock()
+	targets := make([]Target, 0, len(mc.currentTargets))
+	for _, tgt := range mc.currentTargets {
+		targets = append(targets, tgt)
+	}
+	mc.mu.RUnlock()
 
-	var aggregated strings.Builder
+	var wg sync.WaitGroup
+	for _, tgt := range targets {
+		wg.Add(1)
+		go func(tgt Target) {
+			defer wg.Done()
+			mc.scrapeTarget(ctx, tgt)
+		}(tgt)
+	}
+	wg.Wait()
+}
 
-	for containerID, metrics := range mc.metricsCache {
-		aggregated.WriteString(fmt.Sprintf("# Metrics from container %s\n", containerID))
-		aggregated.WriteString(metrics)
-		if !strings.HasSuffix(metrics, "\n") {
-			aggregated.WriteString("\n")
+// discoveredAutoLabels extracts the `prometheus.auto.label.<name>` container
+// labels into the label set handed to Prometheus via HTTP service discovery.
+func discoveredAutoLabels(labels map[string]string) map[string]string {
+	extracted := make(map[string]string)
+	for k, v := range labels {
+		if name := strings.TrimPrefix(k, "prometheus.auto.label."); name != k {
+			extracted[name] = v
 		}
-		aggregated.WriteString("\n")
 	}
-
-	return aggregated.String()
+	return extracted
 }
 
-func (mc *MetricsCollector) metricsHandler(w http.ResponseWriter, r *http.Request) {
-	aggregatedMetrics := mc.aggregateMetrics()
+// decodeMetricFamilies decodes a scrape body into metric families regardless
+// of whether the target replied in the classic text format or OpenMetrics,
+// using expfmt.NewDecoder so exemplars and OpenMetrics' `_created` series
+// survive the round trip through re-emission.
+func decodeMetricFamilies(body string, format expfmt.Format) (map[string]*dto.MetricFamily, error) {
+	decoder := expfmt.NewDecoder(strings.NewReader(body), format)
+
+	families := make(map[string]*dto.MetricFamily)
+	for {
+		var mf dto.MetricFamily
+		if err := decoder.Decode(&mf); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		families[mf.GetName()] = &mf
+	}
 
-	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprint(w, aggregatedMetrics)
+	return families, nil
 }
 
 func main() {
@@ -322,21 +794,56 @@ func main() {
 	}
 
 	reg := prometheus.NewRegistry()
+	reg.MustRegister(collector)
 
 	ctx := context.Background()
-	go func() {
-		ticker := time.NewTicker(30 * time.Second)
-		defer ticker.Stop()
 
-		collector.updateMetrics(ctx)
+	discoverers, err := configureDiscoverers(ctx, collector)
+	if err != nil {
+		log.Fatalf("Failed to configure service discovery: %v", err)
+	}
+	collector.discoverers = discoverers
+
+	remoteWriteEndpoints, err := loadRemoteWriteEndpoints()
+	if err != nil {
+		log.Fatalf("Failed to configure remote write: %v", err)
+	}
+	if len(remoteWriteEndpoints) > 0 {
+		rw, err := newRemoteWriteClient(remoteWriteEndpoints)
+		if err != nil {
+			log.Fatalf("Failed to configure remote write: %v", err)
+		}
+		collector.remoteWrite = rw
+		go rw.run(ctx, 30*time.Second)
 
-		for range ticker.C {
-			collector.updateMetrics(ctx)
+		internalReg := prometheus.NewRegistry()
+		internalReg.MustRegister(rw)
+		http.Handle("/internal/metrics", promhttp.HandlerFor(internalReg, promhttp.HandlerOpts{}))
+	}
+
+	go func() {
+		collector.updateMetrics(ctx)
+		collector.updateContainerStats(ctx)
+
+		discoveryTicker := time.NewTicker(collector.defaultInterval)
+		defer discoveryTicker.Stop()
+		schedulerTicker := time.NewTicker(time.Second)
+		defer schedulerTicker.Stop()
+
+		for {
+			select {
+			case <-discoveryTicker.C:
+				collector.refreshTargets(ctx)
+				collector.updateContainerStats(ctx)
+			case <-schedulerTicker.C:
+				collector.runDueScrapes(ctx)
+			}
 		}
 	}()
 
-	http.HandleFunc("/metrics", collector.metricsHandler)
-	http.Handle("/internal/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	http.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{EnableOpenMetrics: true}))
+	http.HandleFunc("/internal/targets", collector.targetsHandler)
+	http.HandleFunc("/sd", collector.httpSDHandler)
 	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		fmt.Fprint(w, "OK")
@@ -1,25 +1,38 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
-	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
 )
 
 type mockDockerClient struct {
-	containers    []container.Summary
-	containerInfo map[string]container.InspectResponse
-	listError     error
-	inspectError  error
+	containers     []container.Summary
+	containerInfo  map[string]container.InspectResponse
+	containerStats map[string]container.StatsResponse
+	listError      error
+	inspectError   error
+	statsError     error
+
+	services []swarm.Service
+	tasks    []swarm.Task
+	nodes    []swarm.Node
+	swarmErr error
 }
 
 func (m *mockDockerClient) ContainerList(ctx context.Context, options container.ListOptions) ([]container.Summary, error) {
@@ -39,151 +52,36 @@ func (m *mockDockerClient) ContainerInspect(ctx context.Context, containerID str
 	return container.InspectResponse{}, fmt.Errorf("container not found")
 }
 
-func TestDiscoverContainers(t *testing.T) {
-	tests := []struct {
-		name          string
-		containers    []container.Summary
-		listError     error
-		labelFilter   map[string]string
-		expectedCount int
-		expectError   bool
-	}{
-		{
-			name: "discover prometheus enabled containers",
-			containers: []container.Summary{
-				{
-					ID: "container1",
-					Labels: map[string]string{
-						"prometheus.auto.enable": "true",
-						"prometheus.auto.port":   "9090",
-					},
-				},
-				{
-					ID: "container2",
-					Labels: map[string]string{
-						"prometheus.auto.enable": "false",
-					},
-				},
-				{
-					ID: "container3",
-					Labels: map[string]string{
-						"prometheus.auto.enable": "true",
-					},
-				},
-			},
-			expectedCount: 2,
-			expectError:   false,
-		},
-		{
-			name:          "no containers",
-			containers:    []container.Summary{},
-			expectedCount: 0,
-			expectError:   false,
-		},
-		{
-			name:        "docker API error",
-			listError:   fmt.Errorf("docker API error"),
-			expectError: true,
-		},
-		{
-			name: "label filter matches",
-			containers: []container.Summary{
-				{
-					ID: "container1",
-					Labels: map[string]string{
-						"prometheus.auto.enable": "true",
-						"environment":            "production",
-						"service":                "api",
-					},
-				},
-				{
-					ID: "container2",
-					Labels: map[string]string{
-						"prometheus.auto.enable": "true",
-						"environment":            "staging",
-						"service":                "api",
-					},
-				},
-			},
-			labelFilter: map[string]string{
-				"environment": "production",
-			},
-			expectedCount: 1,
-			expectError:   false,
-		},
-		{
-			name: "multiple label filters",
-			containers: []container.Summary{
-				{
-					ID: "container1",
-					Labels: map[string]string{
-						"prometheus.auto.enable": "true",
-						"environment":            "production",
-						"service":                "api",
-					},
-				},
-				{
-					ID: "container2",
-					Labels: map[string]string{
-						"prometheus.auto.enable": "true",
-						"environment":            "production",
-						"service":                "worker",
-					},
-				},
-			},
-			labelFilter: map[string]string{
-				"environment": "production",
-				"service":     "api",
-			},
-			expectedCount: 1,
-			expectError:   false,
-		},
-		{
-			name: "label filter no matches",
-			containers: []container.Summary{
-				{
-					ID: "container1",
-					Labels: map[string]string{
-						"prometheus.auto.enable": "true",
-						"environment":            "staging",
-					},
-				},
-			},
-			labelFilter: map[string]string{
-				"environment": "production",
-			},
-			expectedCount: 0,
-			expectError:   false,
-		},
+func (m *mockDockerClient) ContainerStats(ctx context.Context, containerID string, stream bool) (container.StatsResponseReader, error) {
+	if m.statsError != nil {
+		return container.StatsResponseReader{}, m.statsError
 	}
+	body, err := json.Marshal(m.containerStats[containerID])
+	if err != nil {
+		return container.StatsResponseReader{}, err
+	}
+	return container.StatsResponseReader{Body: io.NopCloser(bytes.NewReader(body))}, nil
+}
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			mc := &MetricsCollector{
-				dockerClient: &mockDockerClient{
-					containers: tt.containers,
-					listError:  tt.listError,
-				},
-				metricsCache: make(map[string]string),
-				labelFilter:  tt.labelFilter,
-			}
+func (m *mockDockerClient) ServiceList(ctx context.Context, options types.ServiceListOptions) ([]swarm.Service, error) {
+	if m.swarmErr != nil {
+		return nil, m.swarmErr
+	}
+	return m.services, nil
+}
 
-			containers, err := mc.discoverContainers(context.Background())
+func (m *mockDockerClient) TaskList(ctx context.Context, options types.TaskListOptions) ([]swarm.Task, error) {
+	if m.swarmErr != nil {
+		return nil, m.swarmErr
+	}
+	return m.tasks, nil
+}
 
-			if tt.expectError {
-				if err == nil {
-					t.Error("expected error but got none")
-				}
-			} else {
-				if err != nil {
-					t.Errorf("unexpected error: %v", err)
-				}
-				if len(containers) != tt.expectedCount {
-					t.Errorf("expected %d containers, got %d", tt.expectedCount, len(containers))
-				}
-			}
-		})
+func (m *mockDockerClient) NodeList(ctx context.Context, options types.NodeListOptions) ([]swarm.Node, error) {
+	if m.swarmErr != nil {
+		return nil, m.swarmErr
 	}
+	return m.nodes, nil
 }
 
 func TestFetchMetrics(t *testing.T) {
@@ -204,8 +102,7 @@ func TestFetchMetrics(t *testing.T) {
 	ip := "127.0.0.1"
 
 	mc := &MetricsCollector{
-		metricsCache: make(map[string]string),
-		labelFilter:  make(map[string]string),
+		labelFilter: make(map[string]string),
 	}
 
 	tests := []struct {
@@ -248,58 +145,149 @@ func TestFetchMetrics(t *testing.T) {
 	}
 }
 
-func TestAggregateMetrics(t *testing.T) {
-	mc := &MetricsCollector{
-		metricsCache: map[string]string{
-			"container1": "# HELP metric1 Test metric 1\nmetric1 10\n",
-			"container2": "# HELP metric2 Test metric 2\nmetric2 20",
-		},
-		labelFilter: make(map[string]string),
+func TestDetectResponseFormatRecognizesOpenMetricsContentType(t *testing.T) {
+	header := http.Header{}
+	header.Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+
+	format := detectResponseFormat(header)
+	if !strings.Contains(string(format), "openmetrics") {
+		t.Errorf("expected OpenMetrics format, got %v", format)
 	}
+}
 
-	aggregated := mc.aggregateMetrics()
+func TestDetectResponseFormatFallsBackToResponseFormat(t *testing.T) {
+	header := http.Header{}
+	header.Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
 
-	if !strings.Contains(aggregated, "container1") {
-		t.Error("expected aggregated metrics to contain container1")
+	if got := detectResponseFormat(header); got != expfmt.FmtText {
+		t.Errorf("expected FmtText for plain text content type, got %v", got)
 	}
-	if !strings.Contains(aggregated, "container2") {
-		t.Error("expected aggregated metrics to contain container2")
+}
+
+func TestFetchMetricsNegotiated(t *testing.T) {
+	var gotAccept string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAccept = r.Header.Get("Accept")
+		w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintln(w, "# TYPE om_metric counter")
+		fmt.Fprintln(w, "om_metric_total 5.0")
+		fmt.Fprintln(w, "# EOF")
+	}))
+	defer server.Close()
+
+	parts := strings.Split(server.URL, ":")
+	port := parts[len(parts)-1]
+
+	mc := &MetricsCollector{labelFilter: make(map[string]string)}
+
+	body, format, err := mc.fetchMetricsNegotiated(context.Background(), Target{ID: "t1"}, "127.0.0.1", port, "openmetrics")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
 	}
-	if !strings.Contains(aggregated, "metric1 10") {
-		t.Error("expected aggregated metrics to contain metric1")
+	if gotAccept != acceptOpenMetrics {
+		t.Errorf("expected Accept header %q, got %q", acceptOpenMetrics, gotAccept)
 	}
-	if !strings.Contains(aggregated, "metric2 20") {
-		t.Error("expected aggregated metrics to contain metric2")
+	if !strings.Contains(string(format), "openmetrics") {
+		t.Errorf("expected OpenMetrics response format, got %v", format)
+	}
+
+	families, err := decodeMetricFamilies(body, format)
+	if err != nil {
+		t.Fatalf("failed to decode OpenMetrics body: %v", err)
+	}
+	if _, ok := families["om_metric"]; !ok {
+		t.Error("expected decoded families to contain om_metric")
 	}
 }
 
-func TestMetricsHandler(t *testing.T) {
+// parseSingleFamily is a test helper that parses one container's exposition
+// text into the metric family map stored on a containerTarget.
+func parseSingleFamily(t *testing.T, text string) map[string]*dto.MetricFamily {
+	t.Helper()
+	parser := expfmt.TextParser{}
+	families, err := parser.TextToMetricFamilies(strings.NewReader(text))
+	if err != nil {
+		t.Fatalf("failed to parse test fixture: %v", err)
+	}
+	return families
+}
+
+func TestCollectInjectsPerContainerLabels(t *testing.T) {
 	mc := &MetricsCollector{
-		metricsCache: map[string]string{
-			"test-container": "# HELP test_metric Test\ntest_metric 100\n",
-		},
 		labelFilter: make(map[string]string),
+		targets: map[string]*containerTarget{
+			"container1": {
+				containerID:    "container1",
+				containerName:  "app-one",
+				image:          "app:latest",
+				families:       parseSingleFamily(t, "# HELP requests_total Requests\n# TYPE requests_total counter\nrequests_total 10\n"),
+				scrapeSuccess:  true,
+				samplesScraped: 1,
+			},
+			"container2": {
+				containerID:    "container2",
+				containerName:  "app-two",
+				image:          "app:latest",
+				families:       parseSingleFamily(t, "# HELP requests_total Requests\n# TYPE requests_total counter\nrequests_total 20\n"),
+				scrapeSuccess:  true,
+				samplesScraped: 1,
+			},
+		},
+		scrapeErrorsTotal: prometheus.NewCounter(prometheus.CounterOpts{Name: "docker_collector_scrape_errors_total"}),
 	}
 
-	req, err := http.NewRequest("GET", "/metrics", nil)
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(mc)
+
+	server := httptest.NewServer(promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
 	if err != nil {
-		t.Fatal(err)
+		t.Fatalf("unexpected error fetching metrics: %v", err)
 	}
+	defer resp.Body.Close()
 
-	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(mc.metricsHandler)
-	handler.ServeHTTP(rr, req)
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read response body: %v", err)
+	}
+	output := string(body)
 
-	if status := rr.Code; status != http.StatusOK {
-		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	if !strings.Contains(output, `container_id="container1"`) {
+		t.Error("expected output to contain container_id label for container1")
+	}
+	if !strings.Contains(output, `container_name="app-two"`) {
+		t.Error("expected output to contain container_name label for app-two")
 	}
+	if !strings.Contains(output, "docker_collector_scrape_success") {
+		t.Error("expected output to contain docker_collector_scrape_success")
+	}
+	if !strings.Contains(output, "docker_collector_scrape_samples_scraped") {
+		t.Error("expected output to contain docker_collector_scrape_samples_scraped")
+	}
+}
 
-	if contentType := rr.Header().Get("Content-Type"); contentType != "text/plain; version=0.0.4" {
-		t.Errorf("handler returned wrong content type: got %v want %v", contentType, "text/plain; version=0.0.4")
+func TestRawMetricWritePreservesUnmodeledSubmessageFields(t *testing.T) {
+	counter := &dto.Counter{Value: floatPtr(42)}
+	original := &dto.Metric{Counter: counter}
+
+	rm := &rawMetric{
+		desc:   prometheus.NewDesc("test_metric", "help", []string{"container_id"}, nil),
+		metric: original,
+		labels: zipLabelPairs([]string{"container_id"}, []string{"c1"}),
 	}
 
-	if !strings.Contains(rr.Body.String(), "test_metric 100") {
-		t.Error("handler did not return expected metrics")
+	var out dto.Metric
+	if err := rm.Write(&out); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out.Counter != counter {
+		t.Error("expected Write to forward the original Counter submessage unmodified, so fields MustNewConstMetric can't express (exemplars, _created timestamps) survive re-emission")
+	}
+	if len(out.Label) != 1 || out.Label[0].GetName() != "container_id" || out.Label[0].GetValue() != "c1" {
+		t.Errorf("expected injected container_id label, got %v", out.Label)
 	}
 }
 
@@ -341,24 +329,26 @@ func TestUpdateMetrics(t *testing.T) {
 	}
 
 	mc := &MetricsCollector{
-		dockerClient: mockClient,
-		metricsCache: make(map[string]string),
-		labelFilter:  make(map[string]string),
+		dockerClient:      mockClient,
+		labelFilter:       make(map[string]string),
+		scrapeErrorsTotal: prometheus.NewCounter(prometheus.CounterOpts{Name: "docker_collector_scrape_errors_total"}),
 	}
 
 	mc.updateMetrics(context.Background())
 
-	time.Sleep(100 * time.Millisecond)
-
 	mc.mu.RLock()
-	metrics, exists := mc.metricsCache["test-container"]
+	target, exists := mc.targets["test-container"]
 	mc.mu.RUnlock()
 
 	if !exists {
-		t.Error("expected metrics for test-container to be cached")
+		t.Fatal("expected a target for test-container to be cached")
+	}
+
+	if !target.scrapeSuccess {
+		t.Error("expected scrape to be marked successful")
 	}
 
-	if !strings.Contains(metrics, "updated_metric 123") {
+	if _, ok := target.families["updated_metric"]; !ok {
 		t.Error("expected cached metrics to contain updated_metric")
 	}
 }
@@ -409,32 +399,30 @@ func TestUpdateMetricsWithDrop(t *testing.T) {
 	}
 
 	mc := &MetricsCollector{
-		dockerClient: mockClient,
-		metricsCache: make(map[string]string),
-		labelFilter:  make(map[string]string),
+		dockerClient:      mockClient,
+		labelFilter:       make(map[string]string),
+		scrapeErrorsTotal: prometheus.NewCounter(prometheus.CounterOpts{Name: "docker_collector_scrape_errors_total"}),
 	}
 
 	mc.updateMetrics(context.Background())
 
-	time.Sleep(100 * time.Millisecond)
-
 	mc.mu.RLock()
-	metrics, exists := mc.metricsCache["test-container-drop"]
+	target, exists := mc.targets["test-container-drop"]
 	mc.mu.RUnlock()
 
 	if !exists {
-		t.Error("expected metrics for test-container-drop to be cached")
+		t.Fatal("expected a target for test-container-drop to be cached")
 	}
 
-	if !strings.Contains(metrics, "keep_metric 100") {
+	if _, ok := target.families["keep_metric"]; !ok {
 		t.Error("expected cached metrics to contain keep_metric")
 	}
 
-	if strings.Contains(metrics, "drop_metric") {
+	if _, ok := target.families["drop_metric"]; ok {
 		t.Error("expected cached metrics to NOT contain drop_metric")
 	}
 
-	if strings.Contains(metrics, "another_drop") {
+	if _, ok := target.families["another_drop"]; ok {
 		t.Error("expected cached metrics to NOT contain another_drop")
 	}
 }
@@ -488,37 +476,29 @@ func TestUpdateMetricsWithRegexDrop(t *testing.T) {
 	}
 
 	mc := &MetricsCollector{
-		dockerClient: mockClient,
-		metricsCache: make(map[string]string),
-		labelFilter:  make(map[string]string),
+		dockerClient:      mockClient,
+		labelFilter:       make(map[string]string),
+		scrapeErrorsTotal: prometheus.NewCounter(prometheus.CounterOpts{Name: "docker_collector_scrape_errors_total"}),
 	}
 
 	mc.updateMetrics(context.Background())
 
-	time.Sleep(100 * time.Millisecond)
-
 	mc.mu.RLock()
-	metrics, exists := mc.metricsCache["test-container-regex"]
+	target, exists := mc.targets["test-container-regex"]
 	mc.mu.RUnlock()
 
 	if !exists {
-		t.Error("expected metrics for test-container-regex to be cached")
+		t.Fatal("expected a target for test-container-regex to be cached")
 	}
 
-	if !strings.Contains(metrics, "http_requests_total 100") {
+	if _, ok := target.families["http_requests_total"]; !ok {
 		t.Error("expected cached metrics to contain http_requests_total")
 	}
 
-	if strings.Contains(metrics, "go_gc_duration_seconds") {
-		t.Error("expected cached metrics to NOT contain go_gc_duration_seconds")
-	}
-
-	if strings.Contains(metrics, "go_threads") {
-		t.Error("expected cached metrics to NOT contain go_threads")
-	}
-
-	if strings.Contains(metrics, "go_memstats_alloc_bytes") {
-		t.Error("expected cached metrics to NOT contain go_memstats_alloc_bytes")
+	for _, dropped := range []string{"go_gc_duration_seconds", "go_threads", "go_memstats_alloc_bytes"} {
+		if _, ok := target.families[dropped]; ok {
+			t.Errorf("expected cached metrics to NOT contain %s", dropped)
+		}
 	}
 }
 
@@ -544,141 +524,6 @@ func TestHealthEndpoint(t *testing.T) {
 	}
 }
 
-func TestFilterMetrics(t *testing.T) {
-	tests := []struct {
-		name     string
-		metrics  string
-		dropList []string
-		expected string
-	}{
-		{
-			name: "no metrics to drop",
-			metrics: `# HELP http_requests_total Total HTTP requests
-# TYPE http_requests_total counter
-http_requests_total{method="GET"} 100
-# HELP cpu_usage CPU usage percentage
-# TYPE cpu_usage gauge
-cpu_usage 45.5`,
-			dropList: []string{},
-			expected: `# HELP http_requests_total Total HTTP requests
-# TYPE http_requests_total counter
-http_requests_total{method="GET"} 100
-# HELP cpu_usage CPU usage percentage
-# TYPE cpu_usage gauge
-cpu_usage 45.5`,
-		},
-		{
-			name: "drop single metric",
-			metrics: `# HELP http_requests_total Total HTTP requests
-# TYPE http_requests_total counter
-http_requests_total{method="GET"} 100
-# HELP cpu_usage CPU usage percentage
-# TYPE cpu_usage gauge
-cpu_usage 45.5`,
-			dropList: []string{"cpu_usage"},
-			expected: `# HELP http_requests_total Total HTTP requests
-# TYPE http_requests_total counter
-http_requests_total{method="GET"} 100`,
-		},
-		{
-			name: "drop multiple metrics",
-			metrics: `# HELP http_requests_total Total HTTP requests
-# TYPE http_requests_total counter
-http_requests_total{method="GET"} 100
-# HELP cpu_usage CPU usage percentage
-# TYPE cpu_usage gauge
-cpu_usage 45.5
-# HELP memory_usage Memory usage
-# TYPE memory_usage gauge
-memory_usage 1024`,
-			dropList: []string{"cpu_usage", "memory_usage"},
-			expected: `# HELP http_requests_total Total HTTP requests
-# TYPE http_requests_total counter
-http_requests_total{method="GET"} 100`,
-		},
-		{
-			name: "drop metric with labels",
-			metrics: `# HELP http_requests_total Total HTTP requests
-# TYPE http_requests_total counter
-http_requests_total{method="GET"} 100
-http_requests_total{method="POST"} 50
-# HELP cpu_usage CPU usage percentage
-# TYPE cpu_usage gauge
-cpu_usage{core="0"} 45.5
-cpu_usage{core="1"} 32.1`,
-			dropList: []string{"cpu_usage"},
-			expected: `# HELP http_requests_total Total HTTP requests
-# TYPE http_requests_total counter
-http_requests_total{method="GET"} 100
-http_requests_total{method="POST"} 50`,
-		},
-		{
-			name: "drop metrics with regex pattern",
-			metrics: `# HELP go_gc_duration_seconds GC duration
-# TYPE go_gc_duration_seconds summary
-go_gc_duration_seconds{quantile="0"} 0.001
-# HELP go_threads Number of OS threads
-# TYPE go_threads gauge
-go_threads 10
-# HELP go_memstats_alloc_bytes Memory allocated
-# TYPE go_memstats_alloc_bytes gauge
-go_memstats_alloc_bytes 1024
-# HELP http_requests_total Total HTTP requests
-# TYPE http_requests_total counter
-http_requests_total 100`,
-			dropList: []string{"go_.*"},
-			expected: `# HELP http_requests_total Total HTTP requests
-# TYPE http_requests_total counter
-http_requests_total 100`,
-		},
-		{
-			name: "drop metrics with multiple patterns",
-			metrics: `# HELP process_cpu_seconds_total CPU time
-# TYPE process_cpu_seconds_total counter
-process_cpu_seconds_total 123.45
-# HELP process_resident_memory_bytes Memory usage
-# TYPE process_resident_memory_bytes gauge
-process_resident_memory_bytes 2048
-# HELP go_threads Number of OS threads
-# TYPE go_threads gauge
-go_threads 10
-# HELP http_requests_total Total HTTP requests
-# TYPE http_requests_total counter
-http_requests_total 100`,
-			dropList: []string{"process_.*", "go_threads"},
-			expected: `# HELP http_requests_total Total HTTP requests
-# TYPE http_requests_total counter
-http_requests_total 100`,
-		},
-		{
-			name: "invalid regex treated as exact match",
-			metrics: `# HELP test[invalid Invalid metric
-# TYPE test[invalid gauge
-test[invalid 42
-# HELP valid_metric Valid metric
-# TYPE valid_metric gauge
-valid_metric 100`,
-			dropList: []string{"test[invalid"},
-			expected: `# HELP valid_metric Valid metric
-# TYPE valid_metric gauge
-valid_metric 100`,
-		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			result := filterMetrics(tt.metrics, tt.dropList)
-			// Normalize line endings for comparison
-			result = strings.TrimSpace(result)
-			expected := strings.TrimSpace(tt.expected)
-
-			if result != expected {
-				t.Errorf("filterMetrics() returned unexpected result.\nGot:\n%s\n\nExpected:\n%s", result, expected)
-			}
-		})
-	}
-}
-
 func TestHTTPSDHandler(t *testing.T) {
 	// Create test targets
 	testTargets := []HTTPSDTarget{
@@ -697,7 +542,6 @@ func TestHTTPSDHandler(t *testing.T) {
 
 	collector := &MetricsCollector{
 		dockerClient: &mockDockerClient{},
-		metricsCache: make(map[string]string),
 		labelFilter:  make(map[string]string),
 		sdTargets:    testTargets,
 	}
@@ -733,12 +577,12 @@ func TestHTTPSDHandler(t *testing.T) {
 		}
 		// Check that labels match
 		if len(target.Labels) != len(testTargets[i].Labels) {
-			t.Errorf("Expected %d labels for target %d, got %d", 
+			t.Errorf("Expected %d labels for target %d, got %d",
 				len(testTargets[i].Labels), i, len(target.Labels))
 		}
 		for k, v := range testTargets[i].Labels {
 			if target.Labels[k] != v {
-				t.Errorf("Expected label %s=%s for target %d, got %s", 
+				t.Errorf("Expected label %s=%s for target %d, got %s",
 					k, v, i, target.Labels[k])
 			}
 		}
@@ -750,8 +594,8 @@ func TestHTTPSDWithAutoLabels(t *testing.T) {
 	mockClient := &mockDockerClient{
 		containers: []container.Summary{
 			{
-				ID:     "container1",
-				Names:  []string{"/app1"},
+				ID:    "container1",
+				Names: []string{"/app1"},
 				Labels: map[string]string{
 					"prometheus.auto.enable":            "true",
 					"prometheus.auto.port":              "8080",
@@ -762,8 +606,8 @@ func TestHTTPSDWithAutoLabels(t *testing.T) {
 				},
 			},
 			{
-				ID:     "container2",
-				Names:  []string{"/app2"},
+				ID:    "container2",
+				Names: []string{"/app2"},
 				Labels: map[string]string{
 					"prometheus.auto.enable": "true",
 					// No prometheus.auto.label.* labels
@@ -791,53 +635,27 @@ func TestHTTPSDWithAutoLabels(t *testing.T) {
 
 	collector := &MetricsCollector{
 		dockerClient: mockClient,
-		metricsCache: make(map[string]string),
 		labelFilter:  make(map[string]string),
 		sdTargets:    []HTTPSDTarget{},
 	}
 
 	// Set up a mock server that the collector won't actually reach
 	// We'll directly populate the sdTargets to test the HTTP SD handler
-	
-	// Simulate what updateMetrics would do
+
+	// Simulate what updateMetrics would do, by running the same discoverer
+	// it would use.
 	ctx := context.Background()
-	containers, _ := collector.discoverContainers(ctx)
-	
+	discoverer := newDockerDiscoverer(mockClient, "docker", collector.labelFilter)
+	discovered, _ := discoverer.Discover(ctx)
+
 	var newTargets []HTTPSDTarget
-	for _, c := range containers {
-		port := c.Labels["prometheus.auto.port"]
-		if port == "" {
-			port = "80"
-		}
-		
-		// Get IP from mock container info
-		containerInfo := mockClient.containerInfo[c.ID]
-		var containerIP string
-		for _, network := range containerInfo.NetworkSettings.Networks {
-			if network.IPAddress != "" {
-				containerIP = network.IPAddress
-				break
-			}
-		}
-		
-		target := HTTPSDTarget{
-			Targets: []string{fmt.Sprintf("%s:%s", containerIP, port)},
-			Labels:  make(map[string]string),
-		}
-		
-		// Only add labels that start with prometheus.auto.label.
-		for k, v := range c.Labels {
-			if strings.HasPrefix(k, "prometheus.auto.label.") {
-				labelName := strings.TrimPrefix(k, "prometheus.auto.label.")
-				if labelName != "" {
-					target.Labels[labelName] = v
-				}
-			}
-		}
-		
-		newTargets = append(newTargets, target)
+	for _, tgt := range discovered {
+		newTargets = append(newTargets, HTTPSDTarget{
+			Targets: []string{tgt.Address},
+			Labels:  discoveredAutoLabels(tgt.Labels),
+		})
 	}
-	
+
 	collector.mu.Lock()
 	collector.sdTargets = newTargets
 	collector.mu.Unlock()
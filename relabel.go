@@ -0,0 +1,325 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+	"gopkg.in/yaml.v3"
+)
+
+// RelabelConfig mirrors one entry of Prometheus's relabel_config, letting
+// operators reshape discovered targets and keep/drop scraped metrics with
+// the same rules they already write for real Prometheus scrape configs.
+type RelabelConfig struct {
+	SourceLabels []string `yaml:"source_labels,omitempty"`
+	Separator    string   `yaml:"separator,omitempty"`
+	TargetLabel  string   `yaml:"target_label,omitempty"`
+	Regex        string   `yaml:"regex,omitempty"`
+	Modulus      uint64   `yaml:"modulus,omitempty"`
+	Replacement  string   `yaml:"replacement,omitempty"`
+	Action       string   `yaml:"action,omitempty"`
+}
+
+// relabelConfigFile is the shape of the YAML file pointed to by
+// PROMETHEUS_RELABEL_CONFIG: a plain list of relabel_config entries, same as
+// Prometheus's own scrape_config.relabel_configs.
+type relabelConfigFile struct {
+	RelabelConfigs []RelabelConfig `yaml:"relabel_configs"`
+}
+
+// compiledRelabelConfig is a RelabelConfig with defaults applied and its
+// regex pre-compiled, ready to be applied to many label sets without
+// re-parsing the pattern each time.
+type compiledRelabelConfig struct {
+	RelabelConfig
+	regex *regexp.Regexp
+}
+
+const defaultRelabelRegex = "(.*)"
+
+// compileRelabelConfig applies Prometheus's relabel_config defaults (action
+// "replace", separator ";", regex "(.*)", replacement "$1") and anchors the
+// regex to match the whole concatenated value, matching relabel.Regexp.
+func compileRelabelConfig(cfg RelabelConfig) (*compiledRelabelConfig, error) {
+	if cfg.Action == "" {
+		cfg.Action = "replace"
+	}
+	if cfg.Separator == "" {
+		cfg.Separator = ";"
+	}
+	if cfg.Regex == "" {
+		cfg.Regex = defaultRelabelRegex
+	}
+	if cfg.Replacement == "" && (cfg.Action == "replace" || cfg.Action == "labelmap") {
+		cfg.Replacement = "$1"
+	}
+	if len(cfg.SourceLabels) == 0 && cfg.Action != "labelmap" && cfg.Action != "labeldrop" && cfg.Action != "labelkeep" {
+		cfg.SourceLabels = []string{"__name__"}
+	}
+
+	re, err := regexp.Compile("^(?:" + cfg.Regex + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid relabel regex %q: %w", cfg.Regex, err)
+	}
+
+	return &compiledRelabelConfig{RelabelConfig: cfg, regex: re}, nil
+}
+
+func compileRelabelConfigs(cfgs []RelabelConfig) ([]*compiledRelabelConfig, error) {
+	compiled := make([]*compiledRelabelConfig, 0, len(cfgs))
+	for _, cfg := range cfgs {
+		c, err := compileRelabelConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, c)
+	}
+	return compiled, nil
+}
+
+// loadRelabelConfigFile reads and parses the YAML file pointed to by
+// PROMETHEUS_RELABEL_CONFIG. It returns the raw configs (not yet compiled) so
+// callers can combine them with rules translated from legacy env vars before
+// compiling the whole set together.
+func loadRelabelConfigFile(path string) ([]RelabelConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read relabel config %s: %w", path, err)
+	}
+
+	var file relabelConfigFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse relabel config %s: %w", path, err)
+	}
+
+	return file.RelabelConfigs, nil
+}
+
+// labelFilterRelabelConfigs translates the legacy PROMETHEUS_LABEL_FILTER env
+// (a comma list of key=value container label requirements) into "keep" rules
+// against the matching __meta_docker_label_* synthetic label, so the old env
+// var keeps working, now expressed through the relabel mechanism.
+func labelFilterRelabelConfigs(labelFilter map[string]string) []RelabelConfig {
+	configs := make([]RelabelConfig, 0, len(labelFilter))
+	for key, value := range labelFilter {
+		configs = append(configs, RelabelConfig{
+			SourceLabels: []string{metaLabelName(key)},
+			Regex:        regexp.QuoteMeta(value),
+			Action:       "keep",
+		})
+	}
+	return configs
+}
+
+// metricsDropRelabelConfigs translates the legacy per-container
+// prometheus.auto.metrics.drop label (a comma list of exact names or regexes)
+// into "drop" rules against __name__, so that label keeps working against
+// the new relabel-based metric filtering. Entries that don't compile as a
+// regex (e.g. a literal name that happens to contain a regex metacharacter,
+// like "test[invalid") fall back to an exact/literal match via
+// regexp.QuoteMeta rather than silently disabling the whole drop rule when
+// compileRelabelConfigs later rejects it.
+func metricsDropRelabelConfigs(dropList []string) []RelabelConfig {
+	configs := make([]RelabelConfig, 0, len(dropList))
+	for _, name := range dropList {
+		regex := name
+		if _, err := regexp.Compile(name); err != nil {
+			regex = regexp.QuoteMeta(name)
+		}
+		configs = append(configs, RelabelConfig{
+			SourceLabels: []string{"__name__"},
+			Regex:        regex,
+			Action:       "drop",
+		})
+	}
+	return configs
+}
+
+// parseContainerRelabelConfigs parses the per-container prometheus.auto.relabel
+// label, a JSON array of relabel_config entries with the same fields as a
+// relabel_configs YAML file entry. yaml.Unmarshal is used to decode it (JSON
+// is a valid YAML subset), so this path doesn't need its own struct-tag
+// scheme alongside RelabelConfig's existing yaml tags.
+func parseContainerRelabelConfigs(raw string) ([]RelabelConfig, error) {
+	var cfgs []RelabelConfig
+	if err := yaml.Unmarshal([]byte(raw), &cfgs); err != nil {
+		return nil, fmt.Errorf("invalid prometheus.auto.relabel: %w", err)
+	}
+	return cfgs, nil
+}
+
+// applyRelabelToFamilies runs rules against every metric sample's full label
+// set (__name__ plus its own labels), not just the family name, so actions
+// like replace/labeldrop/labelkeep/hashmod can rewrite or shard on a metric's
+// actual label values. A metric that ends up with a different __name__ after
+// relabeling is regrouped into the family matching its new name.
+func applyRelabelToFamilies(rules []*compiledRelabelConfig, families map[string]*dto.MetricFamily) map[string]*dto.MetricFamily {
+	result := make(map[string]*dto.MetricFamily, len(families))
+
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			labels := make(map[string]string, len(m.GetLabel())+1)
+			labels["__name__"] = mf.GetName()
+			for _, lp := range m.GetLabel() {
+				labels[lp.GetName()] = lp.GetValue()
+			}
+
+			relabeled := applyRelabel(rules, labels)
+			if !relabeled.keep {
+				continue
+			}
+
+			newName := relabeled.labels["__name__"]
+			if newName == "" {
+				newName = mf.GetName()
+			}
+
+			out, ok := result[newName]
+			if !ok {
+				name := newName
+				out = &dto.MetricFamily{Name: &name, Help: mf.Help, Type: mf.Type}
+				result[newName] = out
+			}
+			m.Label = labelPairsFromMap(relabeled.labels)
+			out.Metric = append(out.Metric, m)
+		}
+	}
+
+	return result
+}
+
+// labelPairsFromMap converts a relabeled working label set back into sorted
+// LabelPairs, dropping __name__ and any other synthetic __-prefixed labels.
+func labelPairsFromMap(labels map[string]string) []*dto.LabelPair {
+	pairs := make([]*dto.LabelPair, 0, len(labels))
+	for name, value := range labels {
+		if strings.HasPrefix(name, "__") {
+			continue
+		}
+		n, v := name, value
+		pairs = append(pairs, &dto.LabelPair{Name: &n, Value: &v})
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].GetName() < pairs[j].GetName() })
+	return pairs
+}
+
+// metaLabelName sanitizes an arbitrary container label key into a valid
+// __meta_docker_label_<name> synthetic label name, the same way Prometheus
+// sanitizes label names derived from external metadata.
+func metaLabelName(key string) string {
+	var b strings.Builder
+	b.WriteString("__meta_docker_label_")
+	for _, r := range key {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('_')
+		}
+	}
+	return b.String()
+}
+
+// relabelResult is what applying a rule set to a label set produces: either
+// the label set survives (possibly modified) or it was dropped.
+type relabelResult struct {
+	labels map[string]string
+	keep   bool
+}
+
+// applyRelabel runs every rule against labels in order, mirroring
+// Prometheus's relabeling semantics: "drop", or a failed "keep", short-
+// circuits to a dropped result; every other action mutates a working copy of
+// the label set that later rules see.
+func applyRelabel(rules []*compiledRelabelConfig, labels map[string]string) relabelResult {
+	working := make(map[string]string, len(labels))
+	for k, v := range labels {
+		working[k] = v
+	}
+
+	for _, rule := range rules {
+		switch rule.Action {
+		case "keep":
+			if !rule.regex.MatchString(concatSourceLabels(working, rule.SourceLabels, rule.Separator)) {
+				return relabelResult{keep: false}
+			}
+		case "drop":
+			if rule.regex.MatchString(concatSourceLabels(working, rule.SourceLabels, rule.Separator)) {
+				return relabelResult{keep: false}
+			}
+		case "replace":
+			if rule.TargetLabel == "" {
+				continue
+			}
+			value := concatSourceLabels(working, rule.SourceLabels, rule.Separator)
+			match := rule.regex.FindStringSubmatchIndex(value)
+			if match == nil {
+				continue
+			}
+			working[rule.TargetLabel] = string(rule.regex.ExpandString(nil, rule.Replacement, value, match))
+		case "labelmap":
+			for name, value := range working {
+				match := rule.regex.FindStringSubmatchIndex(name)
+				if match == nil {
+					continue
+				}
+				newName := string(rule.regex.ExpandString(nil, rule.Replacement, name, match))
+				working[newName] = value
+			}
+		case "labeldrop":
+			for name := range working {
+				if rule.regex.MatchString(name) {
+					delete(working, name)
+				}
+			}
+		case "labelkeep":
+			for name := range working {
+				if !rule.regex.MatchString(name) {
+					delete(working, name)
+				}
+			}
+		case "hashmod":
+			if rule.Modulus == 0 || rule.TargetLabel == "" {
+				continue
+			}
+			value := concatSourceLabels(working, rule.SourceLabels, rule.Separator)
+			sum := sha256.Sum256([]byte(value))
+			hash := binary.BigEndian.Uint64(sum[:8])
+			working[rule.TargetLabel] = strconv.FormatUint(hash%rule.Modulus, 10)
+		}
+	}
+
+	return relabelResult{labels: working, keep: true}
+}
+
+func concatSourceLabels(labels map[string]string, sourceLabels []string, separator string) string {
+	values := make([]string, len(sourceLabels))
+	for i, name := range sourceLabels {
+		values[i] = labels[name]
+	}
+	return strings.Join(values, separator)
+}
+
+// mergeNonMetaLabels copies original, then overlays every label written by
+// relabeling that isn't one of the synthetic __-prefixed ones, so rules can
+// add or rewrite ordinary container labels (e.g. via "replace") without the
+// synthetic bookkeeping labels leaking into emitted metrics.
+func mergeNonMetaLabels(original, relabeled map[string]string) map[string]string {
+	merged := make(map[string]string, len(original))
+	for k, v := range original {
+		merged[k] = v
+	}
+	for k, v := range relabeled {
+		if strings.HasPrefix(k, "__") {
+			continue
+		}
+		merged[k] = v
+	}
+	return merged
+}
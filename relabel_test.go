@@ -0,0 +1,272 @@
+package main
+
+import (
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestApplyRelabelKeepDrop(t *testing.T) {
+	rules, err := compileRelabelConfigs([]RelabelConfig{
+		{SourceLabels: []string{"__meta_docker_label_environment"}, Regex: "production", Action: "keep"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	kept := applyRelabel(rules, map[string]string{"__meta_docker_label_environment": "production"})
+	if !kept.keep {
+		t.Error("expected production target to be kept")
+	}
+
+	dropped := applyRelabel(rules, map[string]string{"__meta_docker_label_environment": "staging"})
+	if dropped.keep {
+		t.Error("expected staging target to be dropped")
+	}
+}
+
+func TestApplyRelabelReplace(t *testing.T) {
+	rules, err := compileRelabelConfigs([]RelabelConfig{
+		{SourceLabels: []string{"__meta_docker_container_name"}, Regex: "(.+)", TargetLabel: "instance", Replacement: "$1:9090", Action: "replace"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	result := applyRelabel(rules, map[string]string{"__meta_docker_container_name": "app-one"})
+	if !result.keep {
+		t.Fatal("expected target to survive a replace rule")
+	}
+	if result.labels["instance"] != "app-one:9090" {
+		t.Errorf("expected instance label app-one:9090, got %s", result.labels["instance"])
+	}
+}
+
+func TestApplyRelabelLabelmapLabeldropLabelkeep(t *testing.T) {
+	labels := map[string]string{
+		"__meta_docker_label_environment": "production",
+		"__meta_docker_label_service":     "api",
+		"__meta_docker_container_id":      "abc123",
+	}
+
+	labelmapRules, err := compileRelabelConfigs([]RelabelConfig{
+		{Regex: "__meta_docker_label_(.+)", Action: "labelmap"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	mapped := applyRelabel(labelmapRules, labels)
+	if mapped.labels["environment"] != "production" || mapped.labels["service"] != "api" {
+		t.Errorf("expected labelmap to copy meta labels without the prefix, got %v", mapped.labels)
+	}
+
+	labeldropRules, err := compileRelabelConfigs([]RelabelConfig{
+		{Regex: "__meta_docker_label_.+", Action: "labeldrop"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	dropped := applyRelabel(labeldropRules, labels)
+	if _, ok := dropped.labels["__meta_docker_label_environment"]; ok {
+		t.Error("expected labeldrop to remove matching labels")
+	}
+	if _, ok := dropped.labels["__meta_docker_container_id"]; !ok {
+		t.Error("expected labeldrop to leave non-matching labels alone")
+	}
+
+	labelkeepRules, err := compileRelabelConfigs([]RelabelConfig{
+		{Regex: "__meta_docker_label_.+", Action: "labelkeep"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	kept := applyRelabel(labelkeepRules, labels)
+	if len(kept.labels) != 2 {
+		t.Errorf("expected labelkeep to leave only the 2 matching labels, got %v", kept.labels)
+	}
+}
+
+func TestApplyRelabelHashmod(t *testing.T) {
+	rules, err := compileRelabelConfigs([]RelabelConfig{
+		{SourceLabels: []string{"__meta_docker_container_id"}, TargetLabel: "shard", Modulus: 4, Action: "hashmod"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	result := applyRelabel(rules, map[string]string{"__meta_docker_container_id": "abc123"})
+	shard, ok := result.labels["shard"]
+	if !ok {
+		t.Fatal("expected hashmod to set the shard label")
+	}
+	if shard != "0" && shard != "1" && shard != "2" && shard != "3" {
+		t.Errorf("expected shard in [0,4), got %s", shard)
+	}
+}
+
+func TestMetricsDropRelabelConfigsFiltersByName(t *testing.T) {
+	tests := []struct {
+		name       string
+		dropList   []string
+		metricName string
+		wantDrop   bool
+	}{
+		{name: "exact match dropped", dropList: []string{"cpu_usage"}, metricName: "cpu_usage", wantDrop: true},
+		{name: "exact match keeps others", dropList: []string{"cpu_usage"}, metricName: "http_requests_total", wantDrop: false},
+		{name: "regex match dropped", dropList: []string{"go_.*"}, metricName: "go_threads", wantDrop: true},
+		{name: "regex match keeps others", dropList: []string{"go_.*"}, metricName: "http_requests_total", wantDrop: false},
+		{name: "invalid regex treated as exact match", dropList: []string{"test[invalid"}, metricName: "test[invalid", wantDrop: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := compileRelabelConfigs(metricsDropRelabelConfigs(tt.dropList))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			result := applyRelabel(rules, map[string]string{"__name__": tt.metricName})
+			if result.keep == tt.wantDrop {
+				t.Errorf("expected keep=%v for metric %s, got keep=%v", !tt.wantDrop, tt.metricName, result.keep)
+			}
+		})
+	}
+}
+
+func TestLabelFilterRelabelConfigsTranslatesEnvFilter(t *testing.T) {
+	rules, err := compileRelabelConfigs(labelFilterRelabelConfigs(map[string]string{"environment": "production"}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !applyRelabel(rules, map[string]string{"__meta_docker_label_environment": "production"}).keep {
+		t.Error("expected matching environment label to be kept")
+	}
+	if applyRelabel(rules, map[string]string{"__meta_docker_label_environment": "staging"}).keep {
+		t.Error("expected non-matching environment label to be dropped")
+	}
+}
+
+func TestMergeNonMetaLabels(t *testing.T) {
+	original := map[string]string{"prometheus.auto.enable": "true"}
+	relabeled := map[string]string{
+		"__address__": "10.0.0.1:9090",
+		"environment": "production",
+	}
+
+	merged := mergeNonMetaLabels(original, relabeled)
+	if merged["prometheus.auto.enable"] != "true" {
+		t.Error("expected original labels to be preserved")
+	}
+	if merged["environment"] != "production" {
+		t.Error("expected non-meta relabeled labels to be merged in")
+	}
+	if _, ok := merged["__address__"]; ok {
+		t.Error("expected synthetic __-prefixed labels to be stripped")
+	}
+}
+
+func TestParseContainerRelabelConfigsDecodesJSONArray(t *testing.T) {
+	cfgs, err := parseContainerRelabelConfigs(`[{"source_labels":["__name__"],"regex":"go_.*","action":"drop"}]`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cfgs) != 1 || cfgs[0].Action != "drop" {
+		t.Fatalf("expected one drop rule, got %+v", cfgs)
+	}
+}
+
+func TestParseContainerRelabelConfigsRejectsMalformedInput(t *testing.T) {
+	if _, err := parseContainerRelabelConfigs(`{not valid json`); err == nil {
+		t.Error("expected an error for malformed prometheus.auto.relabel input")
+	}
+}
+
+func TestApplyRelabelToFamiliesDropsByName(t *testing.T) {
+	rules, err := compileRelabelConfigs([]RelabelConfig{
+		{SourceLabels: []string{"__name__"}, Regex: "go_threads", Action: "drop"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	families := map[string]*dto.MetricFamily{
+		"go_threads": {
+			Name: strPtr("go_threads"), Type: metricTypePtr(dto.MetricType_GAUGE),
+			Metric: []*dto.Metric{{Gauge: &dto.Gauge{Value: floatPtr(3)}}},
+		},
+		"http_requests_total": {
+			Name: strPtr("http_requests_total"), Type: metricTypePtr(dto.MetricType_COUNTER),
+			Metric: []*dto.Metric{{Counter: &dto.Counter{Value: floatPtr(1)}}},
+		},
+	}
+
+	result := applyRelabelToFamilies(rules, families)
+	if _, ok := result["go_threads"]; ok {
+		t.Error("expected go_threads to be dropped")
+	}
+	if _, ok := result["http_requests_total"]; !ok {
+		t.Error("expected http_requests_total to survive")
+	}
+}
+
+func TestApplyRelabelToFamiliesInjectsLabelAndRegroupsOnRename(t *testing.T) {
+	rules, err := compileRelabelConfigs([]RelabelConfig{
+		{TargetLabel: "job", Replacement: "my-app"},
+		{SourceLabels: []string{"__name__"}, Regex: "legacy_requests_total", TargetLabel: "__name__", Replacement: "http_requests_total", Action: "replace"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	families := map[string]*dto.MetricFamily{
+		"legacy_requests_total": {
+			Name: strPtr("legacy_requests_total"), Type: metricTypePtr(dto.MetricType_COUNTER),
+			Metric: []*dto.Metric{{Counter: &dto.Counter{Value: floatPtr(5)}}},
+		},
+	}
+
+	result := applyRelabelToFamilies(rules, families)
+	renamed, ok := result["http_requests_total"]
+	if !ok {
+		t.Fatal("expected the metric to be regrouped under its new name")
+	}
+	if len(renamed.Metric) != 1 {
+		t.Fatalf("expected exactly one metric under the new name, got %d", len(renamed.Metric))
+	}
+	var gotJob string
+	for _, lp := range renamed.Metric[0].GetLabel() {
+		if lp.GetName() == "job" {
+			gotJob = lp.GetValue()
+		}
+	}
+	if gotJob != "my-app" {
+		t.Errorf("expected injected job label my-app, got %q", gotJob)
+	}
+}
+
+func TestApplyRelabelToFamiliesLabeldropRemovesHighCardinalityLabel(t *testing.T) {
+	rules, err := compileRelabelConfigs([]RelabelConfig{
+		{Regex: "request_id", Action: "labeldrop"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	n, v := "request_id", "abc-123"
+	families := map[string]*dto.MetricFamily{
+		"http_requests_total": {
+			Name: strPtr("http_requests_total"), Type: metricTypePtr(dto.MetricType_COUNTER),
+			Metric: []*dto.Metric{{
+				Label:   []*dto.LabelPair{{Name: &n, Value: &v}},
+				Counter: &dto.Counter{Value: floatPtr(1)},
+			}},
+		},
+	}
+
+	result := applyRelabelToFamilies(rules, families)
+	for _, lp := range result["http_requests_total"].Metric[0].GetLabel() {
+		if lp.GetName() == "request_id" {
+			t.Error("expected request_id label to be dropped")
+		}
+	}
+}
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/prometheus/prompb"
+	"gopkg.in/yaml.v3"
+)
+
+// remoteWriteBasicAuth carries HTTP basic auth credentials for one remote
+// write endpoint.
+type remoteWriteBasicAuth struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// remoteWriteTLSConfig mirrors remoteTLSOpts in discovery.go, applied here to
+// the outbound remote-write HTTP client instead of the Docker API client.
+type remoteWriteTLSConfig struct {
+	CAFile             string `yaml:"ca_file,omitempty"`
+	CertFile           string `yaml:"cert_file,omitempty"`
+	KeyFile            string `yaml:"key_file,omitempty"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify,omitempty"`
+}
+
+// remoteWriteEndpoint is one configured remote-write destination.
+type remoteWriteEndpoint struct {
+	URL         string                `yaml:"url"`
+	BasicAuth   *remoteWriteBasicAuth `yaml:"basic_auth,omitempty"`
+	BearerToken string                `yaml:"bearer_token,omitempty"`
+	TLS         *remoteWriteTLSConfig `yaml:"tls,omitempty"`
+}
+
+// remoteWriteConfigFile is the shape of the YAML file pointed to by
+// PROMETHEUS_REMOTE_WRITE_CONFIG.
+type remoteWriteConfigFile struct {
+	Endpoints []remoteWriteEndpoint `yaml:"remote_write"`
+}
+
+// loadRemoteWriteEndpoints combines the quick-start env vars
+// (PROMETHEUS_REMOTE_WRITE_URL plus optional basic auth/bearer token env
+// vars, applied to every URL) with any endpoints declared in the YAML file
+// pointed to by PROMETHEUS_REMOTE_WRITE_CONFIG, for setups that need
+// per-endpoint auth or TLS.
+func loadRemoteWriteEndpoints() ([]remoteWriteEndpoint, error) {
+	var endpoints []remoteWriteEndpoint
+
+	if urls := os.Getenv("PROMETHEUS_REMOTE_WRITE_URL"); urls != "" {
+		var basicAuth *remoteWriteBasicAuth
+		if user := os.Getenv("PROMETHEUS_REMOTE_WRITE_USERNAME"); user != "" {
+			basicAuth = &remoteWriteBasicAuth{Username: user, Password: os.Getenv("PROMETHEUS_REMOTE_WRITE_PASSWORD")}
+		}
+		for _, url := range strings.Split(urls, ",") {
+			endpoints = append(endpoints, remoteWriteEndpoint{
+				URL:         strings.TrimSpace(url),
+				BasicAuth:   basicAuth,
+				BearerToken: os.Getenv("PROMETHEUS_REMOTE_WRITE_BEARER_TOKEN"),
+			})
+		}
+	}
+
+	if configPath := os.Getenv("PROMETHEUS_REMOTE_WRITE_CONFIG"); configPath != "" {
+		data, err := os.ReadFile(configPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read remote write config %s: %w", configPath, err)
+		}
+		var file remoteWriteConfigFile
+		if err := yaml.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("failed to parse remote write config %s: %w", configPath, err)
+		}
+		endpoints = append(endpoints, file.Endpoints...)
+	}
+
+	return endpoints, nil
+}
+
+// remoteWriteHTTPClient builds the *http.Client used to reach one endpoint,
+// wiring up a custom TLS config only when the endpoint needs one.
+func remoteWriteHTTPClient(ep remoteWriteEndpoint) (*http.Client, error) {
+	if ep.TLS == nil {
+		return &http.Client{Timeout: 10 * time.Second}, nil
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: ep.TLS.InsecureSkipVerify}
+	if ep.TLS.CAFile != "" {
+		caCert, err := os.ReadFile(ep.TLS.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file %s: %w", ep.TLS.CAFile, err)
+		}
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM(caCert)
+		tlsConfig.RootCAs = pool
+	}
+	if ep.TLS.CertFile != "" && ep.TLS.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(ep.TLS.CertFile, ep.TLS.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate for %s: %w", ep.URL, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}, nil
+}
+
+const (
+	remoteWriteQueueCapacity = 1000
+	remoteWriteMaxRetries    = 5
+)
+
+// remoteWriteClient periodically forwards scraped samples to one or more
+// Prometheus remote-write 1.0 endpoints, so the collector can run in push
+// mode against a central Prometheus/Mimir/VictoriaMetrics/Thanos Receive
+// that can't reach the Docker host directly. It's registered as its own
+// unchecked prometheus.Collector, exposed separately at /internal/metrics so
+// operators can watch queue health without it polluting per-container
+// metrics.
+type remoteWriteClient struct {
+	endpoints []remoteWriteEndpoint
+	clients   map[string]*http.Client
+
+	mu          sync.Mutex
+	queue       []remoteWriteBatch
+	sendSeconds float64
+
+	failuresTotal *prometheus.CounterVec
+	sentTotal     *prometheus.CounterVec
+}
+
+// remoteWriteBatch pairs a batch of series with the subset of endpoints that
+// still need to receive it, so a batch is only dropped once every endpoint
+// that was supposed to get it has actually accepted it, and a permanent
+// failure on one endpoint never masks (or gets masked by) another endpoint's
+// outcome for the same batch.
+type remoteWriteBatch struct {
+	series    []prompb.TimeSeries
+	endpoints []remoteWriteEndpoint
+}
+
+var (
+	remoteWriteQueueDepthDesc = prometheus.NewDesc(
+		"docker_collector_remote_write_queue_depth",
+		"Number of pending batches queued for remote write delivery.",
+		nil, nil,
+	)
+	remoteWriteSendSecondsDesc = prometheus.NewDesc(
+		"docker_collector_remote_write_send_duration_seconds",
+		"Duration in seconds of the most recent remote write send attempt.",
+		nil, nil,
+	)
+)
+
+func newRemoteWriteClient(endpoints []remoteWriteEndpoint) (*remoteWriteClient, error) {
+	clients := make(map[string]*http.Client, len(endpoints))
+	for _, ep := range endpoints {
+		cli, err := remoteWriteHTTPClient(ep)
+		if err != nil {
+			return nil, err
+		}
+		clients[ep.URL] = cli
+	}
+
+	return &remoteWriteClient{
+		endpoints: endpoints,
+		clients:   clients,
+		failuresTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "docker_collector_remote_write_failures_total",
+			Help: "Total number of remote write batches that failed after retries, per endpoint.",
+		}, []string{"endpoint"}),
+		sentTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "docker_collector_remote_write_sent_total",
+			Help: "Total number of batches successfully sent, per endpoint.",
+		}, []string{"endpoint"}),
+	}, nil
+}
+
+func (c *remoteWriteClient) Describe(ch chan<- *prometheus.Desc) {}
+
+func (c *remoteWriteClient) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	depth := float64(len(c.queue))
+	sendSeconds := c.sendSeconds
+	c.mu.Unlock()
+
+	ch <- prometheus.MustNewConstMetric(remoteWriteQueueDepthDesc, prometheus.GaugeValue, depth)
+	ch <- prometheus.MustNewConstMetric(remoteWriteSendSecondsDesc, prometheus.GaugeValue, sendSeconds)
+	c.failuresTotal.Collect(ch)
+	c.sentTotal.Collect(ch)
+}
+
+// enqueue adds a batch of series to the send queue, dropping the oldest
+// queued batch if the queue is already at capacity so an unreachable
+// endpoint can't grow memory use without bound.
+func (c *remoteWriteClient) enqueue(series []prompb.TimeSeries) {
+	if len(series) == 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.queue) >= remoteWriteQueueCapacity {
+		log.Printf("Remote write queue full, dropping oldest batch")
+		c.queue = c.queue[1:]
+	}
+	c.queue = append(c.queue, remoteWriteBatch{series: series, endpoints: c.endpoints})
+}
+
+// run drains one queued batch per tick, sending it to every configured
+// endpoint with retry/backoff.
+func (c *remoteWriteClient) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.flush(ctx)
+		}
+	}
+}
+
+func (c *remoteWriteClient) flush(ctx context.Context) {
+	c.mu.Lock()
+	if len(c.queue) == 0 {
+		c.mu.Unlock()
+		return
+	}
+	batch := c.queue[0]
+	c.queue = c.queue[1:]
+	c.mu.Unlock()
+
+	start := time.Now()
+	results := c.sendWithRetry(ctx, batch.series, batch.endpoints)
+
+	c.mu.Lock()
+	c.sendSeconds = time.Since(start).Seconds()
+	c.mu.Unlock()
+
+	var failedEndpoints []remoteWriteEndpoint
+	for _, endpoint := range batch.endpoints {
+		if err := results[endpoint.URL]; err != nil {
+			log.Printf("Error sending remote write batch to %s: %v", endpoint.URL, err)
+			c.failuresTotal.WithLabelValues(endpoint.URL).Inc()
+			failedEndpoints = append(failedEndpoints, endpoint)
+			continue
+		}
+		c.sentTotal.WithLabelValues(endpoint.URL).Inc()
+	}
+
+	if len(failedEndpoints) == 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.queue) >= remoteWriteQueueCapacity {
+		log.Printf("Remote write queue full, dropping batch still pending for %d endpoint(s)", len(failedEndpoints))
+		return
+	}
+	c.queue = append(c.queue, remoteWriteBatch{series: batch.series, endpoints: failedEndpoints})
+}
+
+// retryableStatusError flags a remote write failure whose status code is
+// worth retrying (429 or any 5xx), as opposed to a permanent rejection.
+type retryableStatusError struct {
+	statusCode int
+}
+
+func (e *retryableStatusError) Error() string {
+	return fmt.Sprintf("remote write endpoint returned status %d", e.statusCode)
+}
+
+func isRetryableStatus(err error) bool {
+	var statusErr *retryableStatusError
+	return errors.As(err, &statusErr)
+}
+
+// sendWithRetry POSTs the batch to every given endpoint, retrying a given
+// endpoint with exponential backoff while it keeps replying 429/5xx, and
+// returns each endpoint's own outcome keyed by URL so a permanent failure on
+// one endpoint can never be masked by (or mask) another endpoint's success.
+func (c *remoteWriteClient) sendWithRetry(ctx context.Context, series []prompb.TimeSeries, endpoints []remoteWriteEndpoint) map[string]error {
+	results := make(map[string]error, len(endpoints))
+
+	body, err := encodeWriteRequest(series)
+	if err != nil {
+		wrapped := fmt.Errorf("failed to encode write request: %w", err)
+		for _, endpoint := range endpoints {
+			results[endpoint.URL] = wrapped
+		}
+		return results
+	}
+
+	for _, endpoint := range endpoints {
+		var lastErr error
+		backoff := 500 * time.Millisecond
+		for attempt := 0; ; attempt++ {
+			sendErr := c.send(ctx, endpoint, body)
+			if sendErr == nil {
+				lastErr = nil
+				break
+			}
+			lastErr = sendErr
+			if !isRetryableStatus(sendErr) || attempt == remoteWriteMaxRetries {
+				break
+			}
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		results[endpoint.URL] = lastErr
+	}
+	return results
+}
+
+func (c *remoteWriteClient) send(ctx context.Context, endpoint remoteWriteEndpoint, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create remote write request: %w", err)
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if endpoint.BasicAuth != nil {
+		req.SetBasicAuth(endpoint.BasicAuth.Username, endpoint.BasicAuth.Password)
+	}
+	if endpoint.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+endpoint.BearerToken)
+	}
+
+	resp, err := c.clients[endpoint.URL].Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send remote write request to %s: %w", endpoint.URL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode/100 == 5 {
+		return &retryableStatusError{statusCode: resp.StatusCode}
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("remote write endpoint %s returned status %d", endpoint.URL, resp.StatusCode)
+	}
+	return nil
+}
+
+func encodeWriteRequest(series []prompb.TimeSeries) ([]byte, error) {
+	wr := &prompb.WriteRequest{Timeseries: series}
+	data, err := wr.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	return snappy.Encode(nil, data), nil
+}
+
+// seriesFromTarget flattens one container's scraped metric families into the
+// timeseries remote write expects, injecting the same identification labels
+// emitMetricFamily injects when serving /metrics.
+func seriesFromTarget(target *containerTarget, timestampMs int64) []prompb.TimeSeries {
+	baseLabels := []prompb.Label{
+		{Name: "container_id", Value: target.containerID},
+		{Name: "container_name", Value: target.containerName},
+		{Name: "image", Value: target.image},
+	}
+	for name, value := range target.extraLabels {
+		baseLabels = append(baseLabels, prompb.Label{Name: name, Value: value})
+	}
+
+	var series []prompb.TimeSeries
+	for _, mf := range target.families {
+		series = append(series, metricFamilySeries(mf, baseLabels, timestampMs)...)
+	}
+	return series
+}
+
+// metricFamilySeries expands a single metric family into one or more
+// timeseries, splitting summaries/histograms into their _sum/_count/quantile
+// or _bucket series the way a real Prometheus remote write sender would.
+func metricFamilySeries(mf *dto.MetricFamily, baseLabels []prompb.Label, timestampMs int64) []prompb.TimeSeries {
+	var series []prompb.TimeSeries
+	name := mf.GetName()
+
+	for _, m := range mf.GetMetric() {
+		labels := append(append([]prompb.Label{}, baseLabels...), metricLabelPairs(m)...)
+
+		switch mf.GetType() {
+		case dto.MetricType_COUNTER:
+			series = append(series, newTimeSeries(name, labels, m.GetCounter().GetValue(), timestampMs))
+		case dto.MetricType_GAUGE:
+			series = append(series, newTimeSeries(name, labels, m.GetGauge().GetValue(), timestampMs))
+		case dto.MetricType_SUMMARY:
+			s := m.GetSummary()
+			series = append(series, newTimeSeries(name+"_sum", labels, s.GetSampleSum(), timestampMs))
+			series = append(series, newTimeSeries(name+"_count", labels, float64(s.GetSampleCount()), timestampMs))
+			for _, q := range s.GetQuantile() {
+				qLabels := append(append([]prompb.Label{}, labels...), prompb.Label{Name: "quantile", Value: formatFloat(q.GetQuantile())})
+				series = append(series, newTimeSeries(name, qLabels, q.GetValue(), timestampMs))
+			}
+		case dto.MetricType_HISTOGRAM:
+			h := m.GetHistogram()
+			series = append(series, newTimeSeries(name+"_sum", labels, h.GetSampleSum(), timestampMs))
+			series = append(series, newTimeSeries(name+"_count", labels, float64(h.GetSampleCount()), timestampMs))
+			for _, b := range h.GetBucket() {
+				bLabels := append(append([]prompb.Label{}, labels...), prompb.Label{Name: "le", Value: formatFloat(b.GetUpperBound())})
+				series = append(series, newTimeSeries(name+"_bucket", bLabels, float64(b.GetCumulativeCount()), timestampMs))
+			}
+		default:
+			series = append(series, newTimeSeries(name, labels, m.GetUntyped().GetValue(), timestampMs))
+		}
+	}
+
+	return series
+}
+
+func metricLabelPairs(m *dto.Metric) []prompb.Label {
+	labels := make([]prompb.Label, 0, len(m.GetLabel()))
+	for _, lp := range m.GetLabel() {
+		labels = append(labels, prompb.Label{Name: lp.GetName(), Value: lp.GetValue()})
+	}
+	return labels
+}
+
+func newTimeSeries(name string, labels []prompb.Label, value float64, timestampMs int64) prompb.TimeSeries {
+	allLabels := append([]prompb.Label{{Name: "__name__", Value: name}}, labels...)
+	return prompb.TimeSeries{
+		Labels:  allLabels,
+		Samples: []prompb.Sample{{Value: value, Timestamp: timestampMs}},
+	}
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
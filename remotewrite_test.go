@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func TestSeriesFromTargetCounterAndGauge(t *testing.T) {
+	target := &containerTarget{
+		containerID:   "c1",
+		containerName: "app-one",
+		image:         "app:latest",
+		families: map[string]*dto.MetricFamily{
+			"requests_total": {
+				Name: strPtr("requests_total"),
+				Type: metricTypePtr(dto.MetricType_COUNTER),
+				Metric: []*dto.Metric{
+					{Counter: &dto.Counter{Value: floatPtr(42)}},
+				},
+			},
+		},
+	}
+
+	series := seriesFromTarget(target, 1000)
+	if len(series) != 1 {
+		t.Fatalf("expected 1 series, got %d", len(series))
+	}
+
+	labels := labelMap(series[0].Labels)
+	if labels["__name__"] != "requests_total" {
+		t.Errorf("expected __name__ requests_total, got %s", labels["__name__"])
+	}
+	if labels["container_id"] != "c1" {
+		t.Errorf("expected container_id c1, got %s", labels["container_id"])
+	}
+	if series[0].Samples[0].Value != 42 {
+		t.Errorf("expected value 42, got %v", series[0].Samples[0].Value)
+	}
+}
+
+func TestMetricFamilySeriesHistogramExpandsBuckets(t *testing.T) {
+	mf := &dto.MetricFamily{
+		Name: strPtr("request_duration_seconds"),
+		Type: metricTypePtr(dto.MetricType_HISTOGRAM),
+		Metric: []*dto.Metric{
+			{
+				Histogram: &dto.Histogram{
+					SampleCount: uint64Ptr(3),
+					SampleSum:   floatPtr(1.5),
+					Bucket: []*dto.Bucket{
+						{UpperBound: floatPtr(0.5), CumulativeCount: uint64Ptr(1)},
+						{UpperBound: floatPtr(1), CumulativeCount: uint64Ptr(3)},
+					},
+				},
+			},
+		},
+	}
+
+	series := metricFamilySeries(mf, nil, 1000)
+
+	names := map[string]int{}
+	for _, s := range series {
+		names[labelMap(s.Labels)["__name__"]]++
+	}
+	if names["request_duration_seconds_sum"] != 1 {
+		t.Errorf("expected exactly one _sum series, got %d", names["request_duration_seconds_sum"])
+	}
+	if names["request_duration_seconds_count"] != 1 {
+		t.Errorf("expected exactly one _count series, got %d", names["request_duration_seconds_count"])
+	}
+	if names["request_duration_seconds_bucket"] != 2 {
+		t.Errorf("expected 2 _bucket series, got %d", names["request_duration_seconds_bucket"])
+	}
+}
+
+func TestRemoteWriteClientEnqueueDropsOldestOnOverflow(t *testing.T) {
+	c := &remoteWriteClient{}
+	for i := 0; i < remoteWriteQueueCapacity+5; i++ {
+		c.enqueue([]prompb.TimeSeries{{}})
+	}
+
+	if len(c.queue) != remoteWriteQueueCapacity {
+		t.Errorf("expected queue to be bounded at %d, got %d", remoteWriteQueueCapacity, len(c.queue))
+	}
+}
+
+func TestSendEncodesSnappyProtobufWithHeaders(t *testing.T) {
+	var gotEncoding, gotContentType, gotVersion string
+	var gotSeries []prompb.TimeSeries
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotEncoding = r.Header.Get("Content-Encoding")
+		gotContentType = r.Header.Get("Content-Type")
+		gotVersion = r.Header.Get("X-Prometheus-Remote-Write-Version")
+
+		compressed, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Fatalf("failed to read request body: %v", err)
+		}
+		decoded, err := snappy.Decode(nil, compressed)
+		if err != nil {
+			t.Fatalf("failed to decode snappy body: %v", err)
+		}
+		var wr prompb.WriteRequest
+		if err := wr.Unmarshal(decoded); err != nil {
+			t.Fatalf("failed to unmarshal write request: %v", err)
+		}
+		gotSeries = wr.Timeseries
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	endpoint := remoteWriteEndpoint{URL: server.URL}
+	c, err := newRemoteWriteClient([]remoteWriteEndpoint{endpoint})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	series := []prompb.TimeSeries{
+		{Labels: []prompb.Label{{Name: "__name__", Value: "up"}}, Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}}},
+	}
+	results := c.sendWithRetry(context.Background(), series, c.endpoints)
+	if err := results[endpoint.URL]; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if gotEncoding != "snappy" {
+		t.Errorf("expected Content-Encoding snappy, got %s", gotEncoding)
+	}
+	if gotContentType != "application/x-protobuf" {
+		t.Errorf("expected Content-Type application/x-protobuf, got %s", gotContentType)
+	}
+	if gotVersion != "0.1.0" {
+		t.Errorf("expected X-Prometheus-Remote-Write-Version 0.1.0, got %s", gotVersion)
+	}
+	if len(gotSeries) != 1 {
+		t.Fatalf("expected 1 series received, got %d", len(gotSeries))
+	}
+}
+
+func TestSendWithRetryRetriesOn503(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	endpoint := remoteWriteEndpoint{URL: server.URL}
+	c, err := newRemoteWriteClient([]remoteWriteEndpoint{endpoint})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	results := c.sendWithRetry(context.Background(), []prompb.TimeSeries{{}}, c.endpoints)
+	if err := results[endpoint.URL]; err != nil {
+		t.Fatalf("expected eventual success, got error: %v", err)
+	}
+	if attempts != 3 {
+		t.Errorf("expected 3 attempts, got %d", attempts)
+	}
+}
+
+func TestSendWithRetryReportsPerEndpointResults(t *testing.T) {
+	okServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer okServer.Close()
+
+	failServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer failServer.Close()
+
+	ok := remoteWriteEndpoint{URL: okServer.URL}
+	fail := remoteWriteEndpoint{URL: failServer.URL}
+	c, err := newRemoteWriteClient([]remoteWriteEndpoint{ok, fail})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	results := c.sendWithRetry(context.Background(), []prompb.TimeSeries{{}}, c.endpoints)
+	if results[ok.URL] != nil {
+		t.Errorf("expected endpoint %s to succeed, got error: %v", ok.URL, results[ok.URL])
+	}
+	if results[fail.URL] == nil {
+		t.Errorf("expected endpoint %s to fail, got nil error", fail.URL)
+	}
+}
+
+func TestFlushRequeuesBatchOnlyForFailedEndpoints(t *testing.T) {
+	okServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer okServer.Close()
+
+	failServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer failServer.Close()
+
+	ok := remoteWriteEndpoint{URL: okServer.URL}
+	fail := remoteWriteEndpoint{URL: failServer.URL}
+	c, err := newRemoteWriteClient([]remoteWriteEndpoint{ok, fail})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	c.enqueue([]prompb.TimeSeries{{}})
+	c.flush(context.Background())
+
+	if len(c.queue) != 1 {
+		t.Fatalf("expected the batch to be requeued for the failed endpoint, got %d queued batches", len(c.queue))
+	}
+	requeued := c.queue[0]
+	if len(requeued.endpoints) != 1 || requeued.endpoints[0].URL != fail.URL {
+		t.Errorf("expected requeued batch to target only %s, got %v", fail.URL, requeued.endpoints)
+	}
+
+	if got := testutil.ToFloat64(c.sentTotal.WithLabelValues(ok.URL)); got != 1 {
+		t.Errorf("expected sentTotal=1 for %s, got %v", ok.URL, got)
+	}
+	if got := testutil.ToFloat64(c.failuresTotal.WithLabelValues(fail.URL)); got != 1 {
+		t.Errorf("expected failuresTotal=1 for %s, got %v", fail.URL, got)
+	}
+}
+
+func labelMap(labels []prompb.Label) map[string]string {
+	m := make(map[string]string, len(labels))
+	for _, l := range labels {
+		m[l.Name] = l.Value
+	}
+	return m
+}
+
+func strPtr(s string) *string                        { return &s }
+func floatPtr(f float64) *float64                    { return &f }
+func uint64Ptr(u uint64) *uint64                     { return &u }
+func metricTypePtr(t dto.MetricType) *dto.MetricType { return &t }
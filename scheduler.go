@@ -0,0 +1,175 @@
+package main
+
+import (
+	"hash/fnv"
+	"os"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// defaultScrapeInterval and defaultScrapeTimeout apply to targets that don't
+// carry a prometheus.auto.interval / prometheus.auto.timeout label and are
+// used as the fallback when a collector is built without NewMetricsCollector
+// (as the test suite does), so the scheduler never ends up with a zero
+// interval busy-looping.
+const (
+	defaultScrapeInterval = 30 * time.Second
+	defaultScrapeTimeout  = 5 * time.Second
+	maxScrapeBackoff      = 5 * time.Minute
+
+	// timeoutHeadroomThreshold is how close avgDuration can get to the
+	// current timeout before adjustTimeoutForAvgDuration widens it. This is
+	// a proactive signal, distinct from the reactive consecutiveFailures
+	// circuit breaker: a target that's merely trending slower gets more
+	// headroom before a scrape ever actually times out.
+	timeoutHeadroomThreshold = 0.8
+	// maxScrapeTimeoutGrowth caps how far the timeout can be widened from its
+	// configured (label or default) value, so one abnormally slow scrape
+	// can't grow it unbounded.
+	maxScrapeTimeoutGrowth = 4
+)
+
+// targetSchedule tracks the steady-state scheduling state for a single
+// target: when it's next due, how long its last few scrapes have taken on
+// average, and how many times in a row it has failed so the circuit breaker
+// can back it off instead of hammering a dead target every interval.
+type targetSchedule struct {
+	interval            time.Duration
+	timeout             time.Duration
+	baseTimeout         time.Duration
+	timeoutWidenSteps   int
+	nextScrape          time.Time
+	inFlight            bool
+	consecutiveFailures int
+	avgDuration         time.Duration
+}
+
+// newTargetSchedule builds the schedule for a newly discovered target, with
+// its first scrape jittered within one interval so a fleet of containers
+// discovered at the same instant doesn't all scrape on the same tick.
+func newTargetSchedule(interval, timeout time.Duration, now time.Time, jitter time.Duration) *targetSchedule {
+	return &targetSchedule{
+		interval:    interval,
+		timeout:     timeout,
+		baseTimeout: timeout,
+		nextScrape:  now.Add(jitter),
+	}
+}
+
+// recordSuccess resets the circuit breaker and schedules the next scrape one
+// plain interval out.
+func (s *targetSchedule) recordSuccess(now time.Time, duration time.Duration) {
+	s.avgDuration = ewma(s.avgDuration, duration)
+	s.consecutiveFailures = 0
+	s.nextScrape = now.Add(s.interval)
+	s.adjustTimeoutForAvgDuration()
+}
+
+// recordFailure trips the circuit breaker further: each consecutive failure
+// doubles the backoff, capped at maxScrapeBackoff, until the target scrapes
+// successfully again.
+func (s *targetSchedule) recordFailure(now time.Time, duration time.Duration) {
+	s.avgDuration = ewma(s.avgDuration, duration)
+	s.consecutiveFailures++
+
+	backoff := s.interval << uint(min(s.consecutiveFailures, 20))
+	if backoff > maxScrapeBackoff || backoff <= 0 {
+		backoff = maxScrapeBackoff
+	}
+	s.nextScrape = now.Add(backoff)
+	s.adjustTimeoutForAvgDuration()
+}
+
+// adjustTimeoutForAvgDuration doubles the scrape timeout once the rolling
+// average duration closes in on its *baseline* timeout (within
+// timeoutHeadroomThreshold of baseTimeout, not the already-widened s.timeout
+// — comparing against the widened value would make the threshold grow right
+// along with it and further widening unreachable), up to maxScrapeTimeoutGrowth
+// times the target's configured timeout. A target that keeps trending slower
+// this way earns more headroom before it ever actually times out, instead of
+// only reacting after the fact via consecutiveFailures.
+func (s *targetSchedule) adjustTimeoutForAvgDuration() {
+	if s.baseTimeout <= 0 || s.avgDuration <= 0 {
+		return
+	}
+	if float64(s.avgDuration) < float64(s.baseTimeout)*timeoutHeadroomThreshold {
+		return
+	}
+
+	widened := s.baseTimeout << uint(s.timeoutWidenSteps+1)
+	if widened > s.baseTimeout*maxScrapeTimeoutGrowth {
+		return
+	}
+	s.timeoutWidenSteps++
+	s.timeout = widened
+}
+
+// ewma folds a new sample into a rolling average with a 0.3 weight on the
+// latest sample, smoothing out one-off slow scrapes without reacting too
+// slowly to a target that's trending slower.
+func ewma(prev, sample time.Duration) time.Duration {
+	if prev <= 0 {
+		return sample
+	}
+	return time.Duration(float64(prev)*0.7 + float64(sample)*0.3)
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// scrapeIntervalFor resolves a target's scrape interval from its
+// prometheus.auto.interval label, falling back to def.
+func scrapeIntervalFor(labels map[string]string, def time.Duration) time.Duration {
+	if def <= 0 {
+		def = defaultScrapeInterval
+	}
+	if raw := labels["prometheus.auto.interval"]; raw != "" {
+		if d, err := time.ParseDuration(raw); err == nil && d > 0 {
+			return d
+		}
+	}
+	return def
+}
+
+// scrapeTimeoutFor resolves a target's scrape timeout from its
+// prometheus.auto.timeout label, falling back to def.
+func scrapeTimeoutFor(labels map[string]string, def time.Duration) time.Duration {
+	if def <= 0 {
+		def = defaultScrapeTimeout
+	}
+	if raw := labels["prometheus.auto.timeout"]; raw != "" {
+		if d, err := time.ParseDuration(raw); err == nil && d > 0 {
+			return d
+		}
+	}
+	return def
+}
+
+// jitterFor deterministically spreads a target's first scrape somewhere
+// within its interval, derived from the target ID so restarts don't
+// re-synchronize every target's schedule back to the same instant.
+func jitterFor(id string, interval time.Duration) time.Duration {
+	if interval <= 0 {
+		return 0
+	}
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return time.Duration(h.Sum32()%uint32(interval.Milliseconds())) * time.Millisecond
+}
+
+// scrapeConcurrency resolves the worker pool size from
+// PROMETHEUS_SCRAPE_CONCURRENCY, defaulting to min(32, 2*NumCPU) so the
+// collector scales with the host without ever going unbounded.
+func scrapeConcurrency() int {
+	if raw := os.Getenv("PROMETHEUS_SCRAPE_CONCURRENCY"); raw != "" {
+		if n, err := strconv.Atoi(raw); err == nil && n > 0 {
+			return n
+		}
+	}
+	return min(32, 2*runtime.NumCPU())
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTargetScheduleRecordSuccessResetsBackoff(t *testing.T) {
+	now := time.Now()
+	sched := newTargetSchedule(10*time.Second, time.Second, now, 0)
+
+	sched.recordFailure(now, time.Millisecond)
+	sched.recordFailure(now, time.Millisecond)
+	if sched.consecutiveFailures != 2 {
+		t.Fatalf("expected 2 consecutive failures, got %d", sched.consecutiveFailures)
+	}
+
+	sched.recordSuccess(now, time.Millisecond)
+	if sched.consecutiveFailures != 0 {
+		t.Errorf("expected success to reset consecutive failures, got %d", sched.consecutiveFailures)
+	}
+	if !sched.nextScrape.Equal(now.Add(10 * time.Second)) {
+		t.Errorf("expected next scrape one interval out, got %v", sched.nextScrape)
+	}
+}
+
+func TestTargetScheduleRecordFailureBacksOffExponentiallyUpToCap(t *testing.T) {
+	now := time.Now()
+	sched := newTargetSchedule(time.Second, time.Second, now, 0)
+
+	sched.recordFailure(now, time.Millisecond)
+	if got := sched.nextScrape.Sub(now); got != 2*time.Second {
+		t.Errorf("expected first backoff of 2s, got %v", got)
+	}
+
+	sched.recordFailure(now, time.Millisecond)
+	if got := sched.nextScrape.Sub(now); got != 4*time.Second {
+		t.Errorf("expected second backoff of 4s, got %v", got)
+	}
+
+	for i := 0; i < 10; i++ {
+		sched.recordFailure(now, time.Millisecond)
+	}
+	if got := sched.nextScrape.Sub(now); got != maxScrapeBackoff {
+		t.Errorf("expected backoff to cap at %v, got %v", maxScrapeBackoff, got)
+	}
+}
+
+func TestTargetScheduleWidensTimeoutAsAvgDurationApproachesIt(t *testing.T) {
+	now := time.Now()
+	sched := newTargetSchedule(30*time.Second, 2*time.Second, now, 0)
+
+	sched.recordSuccess(now, 1900*time.Millisecond)
+	if sched.timeout != 4*time.Second {
+		t.Fatalf("expected timeout to widen to 4s once avgDuration neared it, got %v", sched.timeout)
+	}
+
+	sched.recordSuccess(now, 3900*time.Millisecond)
+	if sched.timeout != 8*time.Second {
+		t.Fatalf("expected timeout to widen to 8s, got %v", sched.timeout)
+	}
+}
+
+func TestTargetScheduleTimeoutGrowthIsCapped(t *testing.T) {
+	now := time.Now()
+	sched := newTargetSchedule(30*time.Second, 2*time.Second, now, 0)
+
+	for i := 0; i < 10; i++ {
+		sched.recordSuccess(now, 10*time.Second)
+	}
+
+	if max := sched.baseTimeout * maxScrapeTimeoutGrowth; sched.timeout != max {
+		t.Errorf("expected timeout to cap at %v, got %v", max, sched.timeout)
+	}
+}
+
+func TestTargetScheduleKeepsTimeoutWhenAvgDurationIsComfortable(t *testing.T) {
+	now := time.Now()
+	sched := newTargetSchedule(30*time.Second, 2*time.Second, now, 0)
+
+	sched.recordSuccess(now, 200*time.Millisecond)
+	if sched.timeout != 2*time.Second {
+		t.Errorf("expected timeout to stay at its configured value, got %v", sched.timeout)
+	}
+}
+
+func TestScrapeIntervalForLabelOverridesDefault(t *testing.T) {
+	labels := map[string]string{"prometheus.auto.interval": "15s"}
+	if got := scrapeIntervalFor(labels, 30*time.Second); got != 15*time.Second {
+		t.Errorf("expected label override of 15s, got %v", got)
+	}
+
+	if got := scrapeIntervalFor(nil, 30*time.Second); got != 30*time.Second {
+		t.Errorf("expected default of 30s when no label is set, got %v", got)
+	}
+
+	invalid := map[string]string{"prometheus.auto.interval": "not-a-duration"}
+	if got := scrapeIntervalFor(invalid, 30*time.Second); got != 30*time.Second {
+		t.Errorf("expected invalid label to fall back to default, got %v", got)
+	}
+}
+
+func TestScrapeTimeoutForLabelOverridesDefault(t *testing.T) {
+	labels := map[string]string{"prometheus.auto.timeout": "2s"}
+	if got := scrapeTimeoutFor(labels, defaultScrapeTimeout); got != 2*time.Second {
+		t.Errorf("expected label override of 2s, got %v", got)
+	}
+
+	if got := scrapeTimeoutFor(nil, defaultScrapeTimeout); got != defaultScrapeTimeout {
+		t.Errorf("expected default timeout when no label is set, got %v", got)
+	}
+}
+
+func TestJitterForIsDeterministicAndBounded(t *testing.T) {
+	interval := 30 * time.Second
+
+	j1 := jitterFor("container-a", interval)
+	j2 := jitterFor("container-a", interval)
+	if j1 != j2 {
+		t.Errorf("expected jitter to be deterministic for the same ID, got %v and %v", j1, j2)
+	}
+	if j1 < 0 || j1 >= interval {
+		t.Errorf("expected jitter within [0, interval), got %v", j1)
+	}
+
+	if jitterFor("container-a", interval) == jitterFor("container-b", interval) {
+		t.Error("expected different container IDs to usually get different jitter")
+	}
+}
+
+func TestScrapeConcurrencyHonorsEnvOverride(t *testing.T) {
+	t.Setenv("PROMETHEUS_SCRAPE_CONCURRENCY", "7")
+	if got := scrapeConcurrency(); got != 7 {
+		t.Errorf("expected env override of 7, got %d", got)
+	}
+
+	t.Setenv("PROMETHEUS_SCRAPE_CONCURRENCY", "")
+	if got := scrapeConcurrency(); got <= 0 {
+		t.Errorf("expected a positive default, got %d", got)
+	}
+}
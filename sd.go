@@ -0,0 +1,27 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// HTTPSDTarget is one entry of Prometheus's http_sd_config response format,
+// letting a real Prometheus server scrape discovered containers directly
+// instead of going through this collector's own aggregated /metrics.
+type HTTPSDTarget struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// httpSDHandler serves the most recently discovered targets in the shape
+// Prometheus expects from an http_sd_config endpoint.
+func (mc *MetricsCollector) httpSDHandler(w http.ResponseWriter, r *http.Request) {
+	mc.mu.RLock()
+	targets := mc.sdTargets
+	mc.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(targets); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
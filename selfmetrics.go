@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Scrape result categories for docker_collector_scrapes_total, mirroring the
+// handful of failure modes client_golang's promhttp instrumentation
+// distinguishes for its own handler errors.
+const (
+	scrapeResultSuccess         = "success"
+	scrapeResultConnectionError = "connection_error"
+	scrapeResultHTTPError       = "http_error"
+	scrapeResultParseError      = "parse_error"
+	scrapeResultTimeout         = "timeout"
+)
+
+// scrapeError tags an error with the result category it should be counted
+// under, so scrapeTarget doesn't have to re-derive it from error string
+// matching.
+type scrapeError struct {
+	category string
+	err      error
+}
+
+func (e *scrapeError) Error() string { return e.err.Error() }
+func (e *scrapeError) Unwrap() error { return e.err }
+
+// classifyScrapeError maps a scrape error to its docker_collector_scrapes_total
+// result label, defaulting unclassified errors to connection_error.
+func classifyScrapeError(err error) string {
+	if err == nil {
+		return scrapeResultSuccess
+	}
+	var se *scrapeError
+	if errors.As(err, &se) {
+		return se.category
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return scrapeResultTimeout
+	}
+	return scrapeResultConnectionError
+}
+
+// newSelfMetrics builds the collector's self-observability instruments. They
+// are real prometheus.Collector implementations (CounterVec/HistogramVec/
+// Gauge) rather than the unchecked const-metric style used for scraped
+// container metrics, since their label sets are known up front.
+type selfMetrics struct {
+	scrapesTotal            *prometheus.CounterVec
+	scrapeDurationHistogram *prometheus.HistogramVec
+	discoveredContainers    prometheus.Gauge
+	cacheEntries            prometheus.Gauge
+	lastUpdateTimestamp     prometheus.Gauge
+}
+
+func newSelfMetrics() *selfMetrics {
+	return &selfMetrics{
+		scrapesTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "docker_collector_scrapes_total",
+			Help: "Total number of scrape attempts per container, by result.",
+		}, []string{"container", "result"}),
+		// Named scrape_attempt_duration_seconds, distinct from the existing
+		// docker_collector_scrape_duration_seconds gauge (last scrape's
+		// duration): this is a histogram across every attempt, success or not.
+		scrapeDurationHistogram: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "docker_collector_scrape_attempt_duration_seconds",
+			Help:    "Histogram of scrape attempt durations per container, including failed attempts.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"container"}),
+		discoveredContainers: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "docker_collector_discovered_containers",
+			Help: "Number of containers currently discovered as scrape targets.",
+		}),
+		cacheEntries: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "docker_collector_cache_entries",
+			Help: "Number of container targets currently cached.",
+		}),
+		lastUpdateTimestamp: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "docker_collector_last_update_timestamp_seconds",
+			Help: "Unix timestamp of the last completed target discovery cycle.",
+		}),
+	}
+}
+
+// collect sends every self-metric instrument's samples onto ch, the same way
+// mc.scrapeErrorsTotal is sent directly in MetricsCollector.Collect.
+func (s *selfMetrics) collect(ch chan<- prometheus.Metric) {
+	s.scrapesTotal.Collect(ch)
+	s.scrapeDurationHistogram.Collect(ch)
+	s.discoveredContainers.Collect(ch)
+	s.cacheEntries.Collect(ch)
+	s.lastUpdateTimestamp.Collect(ch)
+}
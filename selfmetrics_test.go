@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestScrapeTargetRecordsConnectionErrorResult(t *testing.T) {
+	mc := &MetricsCollector{
+		dockerClient:      &mockDockerClient{},
+		scrapeErrorsTotal: prometheus.NewCounter(prometheus.CounterOpts{Name: "docker_collector_scrape_errors_total"}),
+	}
+
+	tgt := Target{ID: "c1", Name: "c1", Address: "127.0.0.1:1", Labels: map[string]string{}}
+	mc.scrapeTarget(context.Background(), tgt)
+
+	if got := testutil.ToFloat64(mc.selfMetrics.scrapesTotal.WithLabelValues("c1", scrapeResultConnectionError)); got != 1 {
+		t.Errorf("expected connection_error count of 1, got %v", got)
+	}
+}
+
+func TestScrapeTargetRecordsHTTPErrorResult(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	mc := &MetricsCollector{
+		dockerClient:      &mockDockerClient{},
+		scrapeErrorsTotal: prometheus.NewCounter(prometheus.CounterOpts{Name: "docker_collector_scrape_errors_total"}),
+	}
+
+	tgt := Target{ID: "c2", Name: "c2", Address: strings.TrimPrefix(server.URL, "http://"), Labels: map[string]string{}}
+	mc.scrapeTarget(context.Background(), tgt)
+
+	if got := testutil.ToFloat64(mc.selfMetrics.scrapesTotal.WithLabelValues("c2", scrapeResultHTTPError)); got != 1 {
+		t.Errorf("expected http_error count of 1, got %v", got)
+	}
+}
+
+func TestScrapeTargetRecordsParseErrorResult(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("this is not a valid exposition body {{{"))
+	}))
+	defer server.Close()
+
+	mc := &MetricsCollector{
+		dockerClient:      &mockDockerClient{},
+		scrapeErrorsTotal: prometheus.NewCounter(prometheus.CounterOpts{Name: "docker_collector_scrape_errors_total"}),
+	}
+
+	tgt := Target{ID: "c3", Name: "c3", Address: strings.TrimPrefix(server.URL, "http://"), Labels: map[string]string{}}
+	mc.scrapeTarget(context.Background(), tgt)
+
+	if got := testutil.ToFloat64(mc.selfMetrics.scrapesTotal.WithLabelValues("c3", scrapeResultParseError)); got != 1 {
+		t.Errorf("expected parse_error count of 1, got %v", got)
+	}
+}
+
+func TestScrapeTargetRecordsSuccessResult(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("some_metric 1\n"))
+	}))
+	defer server.Close()
+
+	mc := &MetricsCollector{
+		dockerClient:      &mockDockerClient{},
+		scrapeErrorsTotal: prometheus.NewCounter(prometheus.CounterOpts{Name: "docker_collector_scrape_errors_total"}),
+	}
+
+	tgt := Target{ID: "c4", Name: "c4", Address: strings.TrimPrefix(server.URL, "http://"), Labels: map[string]string{}}
+	mc.scrapeTarget(context.Background(), tgt)
+
+	if got := testutil.ToFloat64(mc.selfMetrics.scrapesTotal.WithLabelValues("c4", scrapeResultSuccess)); got != 1 {
+		t.Errorf("expected success count of 1, got %v", got)
+	}
+	if got := testutil.CollectAndCount(mc.selfMetrics.scrapeDurationHistogram); got != 1 {
+		t.Errorf("expected one observation recorded in the duration histogram, got %v", got)
+	}
+}
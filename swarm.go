@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
+)
+
+// swarmLabelKeys are the synthetic identification labels swarmDiscoverer
+// attaches to every task it discovers, alongside the service's own labels.
+var swarmLabelKeys = []string{"swarm_service", "swarm_task_id", "swarm_node", "swarm_stack"}
+
+// swarmLabels extracts the swarm_* identification labels from a target's
+// label set, the same way containerExtraLabels extracts prometheus.auto.labels
+// declared ones, so callers that want to surface them (metric emission,
+// HTTP SD) don't have to know swarmDiscoverer's internals.
+func swarmLabels(labels map[string]string) map[string]string {
+	extracted := make(map[string]string)
+	for _, key := range swarmLabelKeys {
+		if v, ok := labels[key]; ok {
+			extracted[key] = v
+		}
+	}
+	return extracted
+}
+
+// swarmDiscoverer discovers scrape targets from a Swarm cluster's running
+// tasks, rather than individual containers: a service opts in once via
+// prometheus.auto.enable on its spec labels, and every replica's task is
+// discovered automatically.
+type swarmDiscoverer struct {
+	client      DockerClient
+	source      string
+	labelFilter map[string]string
+}
+
+func newSwarmDiscoverer(cli DockerClient, labelFilter map[string]string) *swarmDiscoverer {
+	return &swarmDiscoverer{client: cli, source: "swarm", labelFilter: labelFilter}
+}
+
+func (d *swarmDiscoverer) Discover(ctx context.Context) ([]Target, error) {
+	services, err := d.client.ServiceList(ctx, types.ServiceListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list swarm services: %w", err)
+	}
+
+	enabled := make(map[string]swarm.Service)
+	for _, svc := range services {
+		if svc.Spec.Labels["prometheus.auto.enable"] != "true" {
+			continue
+		}
+		if !matchesLabelFilter(svc.Spec.Labels, d.labelFilter) {
+			continue
+		}
+		enabled[svc.ID] = svc
+	}
+	if len(enabled) == 0 {
+		return nil, nil
+	}
+
+	tasks, err := d.client.TaskList(ctx, types.TaskListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list swarm tasks: %w", err)
+	}
+
+	nodes, err := d.client.NodeList(ctx, types.NodeListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list swarm nodes: %w", err)
+	}
+	nodeHostnames := make(map[string]string, len(nodes))
+	for _, n := range nodes {
+		nodeHostnames[n.ID] = n.Description.Hostname
+	}
+
+	var targets []Target
+	for _, task := range tasks {
+		svc, ok := enabled[task.ServiceID]
+		if !ok {
+			continue
+		}
+		if task.Status.State != swarm.TaskStateRunning {
+			continue
+		}
+
+		ip := firstTaskIP(task)
+		if ip == "" {
+			log.Printf("No IP address found for swarm task %s", task.ID)
+			continue
+		}
+
+		labels := make(map[string]string, len(svc.Spec.Labels)+4)
+		for k, v := range svc.Spec.Labels {
+			labels[k] = v
+		}
+		labels["swarm_service"] = svc.Spec.Name
+		labels["swarm_task_id"] = task.ID
+		labels["swarm_node"] = nodeHostnames[task.NodeID]
+		if stack := svc.Spec.Labels["com.docker.stack.namespace"]; stack != "" {
+			labels["swarm_stack"] = stack
+		}
+
+		targets = append(targets, Target{
+			ID:      task.ID,
+			Name:    fmt.Sprintf("%s.%d", svc.Spec.Name, task.Slot),
+			Image:   svc.Spec.TaskTemplate.ContainerSpec.Image,
+			Address: net.JoinHostPort(ip, containerPort(labels)),
+			Labels:  labels,
+			Source:  d.source,
+		})
+	}
+
+	return targets, nil
+}
+
+// firstTaskIP returns the first task network attachment's address with its
+// CIDR suffix stripped, mirroring firstContainerIP for plain containers.
+func firstTaskIP(task swarm.Task) string {
+	for _, attachment := range task.NetworksAttachments {
+		for _, addr := range attachment.Addresses {
+			return strings.SplitN(addr, "/", 2)[0]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/docker/docker/api/types/swarm"
+)
+
+func TestSwarmDiscovererDiscoversEnabledServiceTasks(t *testing.T) {
+	mockClient := &mockDockerClient{
+		services: []swarm.Service{
+			{
+				ID: "svc1",
+				Spec: swarm.ServiceSpec{
+					Annotations: swarm.Annotations{
+						Name:   "web",
+						Labels: map[string]string{"prometheus.auto.enable": "true", "com.docker.stack.namespace": "myapp"},
+					},
+					TaskTemplate: swarm.TaskSpec{ContainerSpec: &swarm.ContainerSpec{Image: "web:latest"}},
+				},
+			},
+			{
+				ID: "svc2",
+				Spec: swarm.ServiceSpec{
+					Annotations: swarm.Annotations{Name: "db", Labels: map[string]string{}},
+				},
+			},
+		},
+		tasks: []swarm.Task{
+			{
+				ID: "task1", ServiceID: "svc1", NodeID: "node1", Slot: 1,
+				Status:              swarm.TaskStatus{State: swarm.TaskStateRunning},
+				NetworksAttachments: []swarm.NetworkAttachment{{Addresses: []string{"10.0.0.5/24"}}},
+			},
+			{
+				ID: "task2", ServiceID: "svc1", NodeID: "node1", Slot: 2,
+				Status:              swarm.TaskStatus{State: swarm.TaskStatePending},
+				NetworksAttachments: []swarm.NetworkAttachment{{Addresses: []string{"10.0.0.6/24"}}},
+			},
+			{
+				ID: "task3", ServiceID: "svc2", NodeID: "node1", Slot: 1,
+				Status:              swarm.TaskStatus{State: swarm.TaskStateRunning},
+				NetworksAttachments: []swarm.NetworkAttachment{{Addresses: []string{"10.0.0.7/24"}}},
+			},
+		},
+		nodes: []swarm.Node{
+			{ID: "node1", Description: swarm.NodeDescription{Hostname: "node-a"}},
+		},
+	}
+
+	d := newSwarmDiscoverer(mockClient, nil)
+	targets, err := d.Discover(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(targets) != 1 {
+		t.Fatalf("expected exactly one target (pending task and disabled service excluded), got %d", len(targets))
+	}
+
+	tgt := targets[0]
+	if tgt.ID != "task1" {
+		t.Errorf("expected target ID task1, got %s", tgt.ID)
+	}
+	if tgt.Address != "10.0.0.5:80" {
+		t.Errorf("expected address 10.0.0.5:80, got %s", tgt.Address)
+	}
+	if tgt.Labels["swarm_service"] != "web" {
+		t.Errorf("expected swarm_service label web, got %s", tgt.Labels["swarm_service"])
+	}
+	if tgt.Labels["swarm_task_id"] != "task1" {
+		t.Errorf("expected swarm_task_id label task1, got %s", tgt.Labels["swarm_task_id"])
+	}
+	if tgt.Labels["swarm_node"] != "node-a" {
+		t.Errorf("expected swarm_node label node-a, got %s", tgt.Labels["swarm_node"])
+	}
+	if tgt.Labels["swarm_stack"] != "myapp" {
+		t.Errorf("expected swarm_stack label myapp, got %s", tgt.Labels["swarm_stack"])
+	}
+}
+
+func TestSwarmDiscovererSkipsEntirelyWhenNoServiceOptsIn(t *testing.T) {
+	mockClient := &mockDockerClient{
+		services: []swarm.Service{
+			{ID: "svc1", Spec: swarm.ServiceSpec{Annotations: swarm.Annotations{Name: "web", Labels: map[string]string{}}}},
+		},
+	}
+
+	d := newSwarmDiscoverer(mockClient, nil)
+	targets, err := d.Discover(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(targets) != 0 {
+		t.Errorf("expected no targets, got %d", len(targets))
+	}
+}
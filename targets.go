@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+	"unicode/utf8"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// scrapeHistorySize bounds how many past scrape results are retained per
+// container, so operators can see recent flapping without the collector's
+// memory growing unbounded.
+const scrapeHistorySize = 5
+
+// ScrapeResult mirrors the outcome of scraping a single container, in the
+// same spirit as the Prometheus API client's warnings-alongside-success
+// responses: callers get the metrics AND anything suspicious about them,
+// rather than suspicious conditions only reaching the logs.
+type ScrapeResult struct {
+	Metrics     map[string]*dto.MetricFamily
+	Warnings    []string
+	Err         error
+	Duration    time.Duration
+	SampleCount int
+	Timestamp   time.Time
+}
+
+// TargetStatus is the JSON-serializable view of a container target exposed at
+// /internal/targets, analogous to Prometheus's /api/v1/targets.
+type TargetStatus struct {
+	ContainerID   string               `json:"containerId"`
+	ContainerName string               `json:"containerName"`
+	Labels        map[string]string    `json:"labels"`
+	LastScrape    time.Time            `json:"lastScrape"`
+	LastError     string               `json:"lastError,omitempty"`
+	Health        string               `json:"health"`
+	Warnings      []string             `json:"warnings,omitempty"`
+	Source        string               `json:"source"`
+	History       []ScrapeHistoryEntry `json:"history,omitempty"`
+}
+
+// ScrapeHistoryEntry is the JSON-serializable view of a single past scrape
+// recorded in scrapeHistory, oldest to newest.
+type ScrapeHistoryEntry struct {
+	Timestamp   time.Time     `json:"timestamp"`
+	Duration    time.Duration `json:"duration"`
+	SampleCount int           `json:"sampleCount"`
+	Err         string        `json:"err,omitempty"`
+}
+
+// recordScrapeResult appends a scrape result to the bounded history for a
+// container, dropping the oldest entry once scrapeHistorySize is exceeded.
+func (mc *MetricsCollector) recordScrapeResult(containerID string, result ScrapeResult) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	if mc.scrapeHistory == nil {
+		mc.scrapeHistory = make(map[string][]ScrapeResult)
+	}
+
+	history := append(mc.scrapeHistory[containerID], result)
+	if len(history) > scrapeHistorySize {
+		history = history[len(history)-scrapeHistorySize:]
+	}
+	mc.scrapeHistory[containerID] = history
+}
+
+// scrapeHistoryEntries converts a container's recorded scrape history into
+// its JSON-serializable form for targetsHandler.
+func scrapeHistoryEntries(history []ScrapeResult) []ScrapeHistoryEntry {
+	if len(history) == 0 {
+		return nil
+	}
+
+	entries := make([]ScrapeHistoryEntry, len(history))
+	for i, result := range history {
+		errMsg := ""
+		if result.Err != nil {
+			errMsg = result.Err.Error()
+		}
+		entries[i] = ScrapeHistoryEntry{
+			Timestamp:   result.Timestamp,
+			Duration:    result.Duration,
+			SampleCount: result.SampleCount,
+			Err:         errMsg,
+		}
+	}
+	return entries
+}
+
+// detectCrossContainerWarnings flags metric names that were scraped from more
+// than one container in the same update cycle. Per-container labels keep the
+// series from actually colliding once emitted, but a duplicate name is still
+// worth surfacing since it usually means two unrelated exporters chose the
+// same metric name.
+func detectCrossContainerWarnings(newTargets map[string]*containerTarget) map[string][]string {
+	nameOwners := make(map[string][]string)
+	for containerID, target := range newTargets {
+		for name := range target.families {
+			nameOwners[name] = append(nameOwners[name], containerID)
+		}
+	}
+
+	warnings := make(map[string][]string)
+	for name, owners := range nameOwners {
+		if len(owners) < 2 {
+			continue
+		}
+		for _, containerID := range owners {
+			warnings[containerID] = append(warnings[containerID], "duplicate metric name across containers: "+name)
+		}
+	}
+	return warnings
+}
+
+// validateBodyEncoding returns a warning if the scraped body isn't valid
+// UTF-8. The scrape itself isn't failed outright, since the text parser may
+// still make sense of the ASCII-compatible parts.
+func validateBodyEncoding(body string) []string {
+	if !utf8.ValidString(body) {
+		return []string{"target returned non-UTF8 body"}
+	}
+	return nil
+}
+
+// targetsHandler serves the current health and warnings of every known
+// container target as JSON, giving operators a debugging surface the
+// log-only approach lacked.
+func (mc *MetricsCollector) targetsHandler(w http.ResponseWriter, r *http.Request) {
+	mc.mu.RLock()
+	statuses := make([]TargetStatus, 0, len(mc.targets))
+	for _, target := range mc.targets {
+		health := "down"
+		if target.scrapeSuccess {
+			health = "up"
+		}
+
+		lastError := ""
+		if target.lastErr != nil {
+			lastError = target.lastErr.Error()
+		}
+
+		statuses = append(statuses, TargetStatus{
+			ContainerID:   target.containerID,
+			ContainerName: target.containerName,
+			Labels:        target.labels,
+			LastScrape:    target.lastScrape,
+			LastError:     lastError,
+			Health:        health,
+			Warnings:      target.warnings,
+			Source:        target.source,
+			History:       scrapeHistoryEntries(mc.scrapeHistory[target.containerID]),
+		})
+	}
+	mc.mu.RUnlock()
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].ContainerName < statuses[j].ContainerName })
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(statuses); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestTargetsHandlerReportsHealthAndWarnings(t *testing.T) {
+	mc := &MetricsCollector{
+		labelFilter: make(map[string]string),
+		targets: map[string]*containerTarget{
+			"up-container": {
+				containerID:   "up-container",
+				containerName: "app-up",
+				labels:        map[string]string{"prometheus.auto.enable": "true"},
+				scrapeSuccess: true,
+				warnings:      []string{"duplicate metric name across containers: requests_total"},
+			},
+			"down-container": {
+				containerID:   "down-container",
+				containerName: "app-down",
+				scrapeSuccess: false,
+				lastErr:       errFetchFailed,
+			},
+		},
+	}
+
+	req := httptest.NewRequest("GET", "/internal/targets", nil)
+	rr := httptest.NewRecorder()
+	mc.targetsHandler(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+
+	var statuses []TargetStatus
+	if err := json.Unmarshal(rr.Body.Bytes(), &statuses); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if len(statuses) != 2 {
+		t.Fatalf("expected 2 target statuses, got %d", len(statuses))
+	}
+
+	// Sorted by container name: app-down, app-up.
+	if statuses[0].Health != "down" || statuses[0].LastError == "" {
+		t.Errorf("expected app-down to be reported down with an error, got %+v", statuses[0])
+	}
+	if statuses[1].Health != "up" || len(statuses[1].Warnings) != 1 {
+		t.Errorf("expected app-up to be healthy with one warning, got %+v", statuses[1])
+	}
+}
+
+func TestTargetsHandlerIncludesScrapeHistory(t *testing.T) {
+	mc := &MetricsCollector{
+		labelFilter: make(map[string]string),
+		targets: map[string]*containerTarget{
+			"c1": {containerID: "c1", containerName: "app-one", scrapeSuccess: true},
+		},
+	}
+	mc.recordScrapeResult("c1", ScrapeResult{SampleCount: 3})
+	mc.recordScrapeResult("c1", ScrapeResult{SampleCount: 5, Err: errFetchFailed})
+
+	req := httptest.NewRequest("GET", "/internal/targets", nil)
+	rr := httptest.NewRecorder()
+	mc.targetsHandler(rr, req)
+
+	var statuses []TargetStatus
+	if err := json.Unmarshal(rr.Body.Bytes(), &statuses); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if len(statuses) != 1 {
+		t.Fatalf("expected 1 target status, got %d", len(statuses))
+	}
+
+	history := statuses[0].History
+	if len(history) != 2 {
+		t.Fatalf("expected 2 history entries, got %d", len(history))
+	}
+	if history[0].SampleCount != 3 || history[1].SampleCount != 5 {
+		t.Errorf("expected history in recorded order, got %+v", history)
+	}
+	if history[1].Err == "" {
+		t.Errorf("expected the second entry's error to be reported, got %+v", history[1])
+	}
+}
+
+func TestDetectCrossContainerWarnings(t *testing.T) {
+	targets := map[string]*containerTarget{
+		"a": {families: map[string]*dto.MetricFamily{"shared_metric": {}}},
+		"b": {families: map[string]*dto.MetricFamily{"shared_metric": {}, "unique_metric": {}}},
+	}
+
+	warnings := detectCrossContainerWarnings(targets)
+
+	if len(warnings["a"]) != 1 || !strings.Contains(warnings["a"][0], "shared_metric") {
+		t.Errorf("expected container a to get a duplicate warning for shared_metric, got %v", warnings["a"])
+	}
+	if len(warnings["b"]) != 1 {
+		t.Errorf("expected container b to get exactly one duplicate warning, got %v", warnings["b"])
+	}
+}
+
+func TestRecordScrapeResultBoundsHistory(t *testing.T) {
+	mc := &MetricsCollector{labelFilter: make(map[string]string)}
+
+	for i := 0; i < scrapeHistorySize+3; i++ {
+		mc.recordScrapeResult("container1", ScrapeResult{SampleCount: i})
+	}
+
+	mc.mu.RLock()
+	history := mc.scrapeHistory["container1"]
+	mc.mu.RUnlock()
+
+	if len(history) != scrapeHistorySize {
+		t.Fatalf("expected history to be bounded to %d entries, got %d", scrapeHistorySize, len(history))
+	}
+	if history[len(history)-1].SampleCount != scrapeHistorySize+2 {
+		t.Errorf("expected the most recent result to be retained, got %+v", history[len(history)-1])
+	}
+}
+
+func TestUpdateMetricsRecordsFetchError(t *testing.T) {
+	mockClient := &mockDockerClient{
+		containers: []container.Summary{
+			{
+				ID: "unreachable-container",
+				Labels: map[string]string{
+					"prometheus.auto.enable": "true",
+					"prometheus.auto.port":   "1",
+				},
+			},
+		},
+		containerInfo: map[string]container.InspectResponse{
+			"unreachable-container": {
+				ContainerJSONBase: &container.ContainerJSONBase{ID: "unreachable-container"},
+				NetworkSettings: &container.NetworkSettings{
+					Networks: map[string]*network.EndpointSettings{
+						"bridge": {IPAddress: "127.0.0.1"},
+					},
+				},
+			},
+		},
+	}
+
+	mc := &MetricsCollector{
+		dockerClient:      mockClient,
+		labelFilter:       make(map[string]string),
+		scrapeErrorsTotal: prometheus.NewCounter(prometheus.CounterOpts{Name: "docker_collector_scrape_errors_total"}),
+	}
+
+	mc.updateMetrics(context.Background())
+
+	mc.mu.RLock()
+	target, exists := mc.targets["unreachable-container"]
+	mc.mu.RUnlock()
+
+	if !exists {
+		t.Fatal("expected a target to be recorded even when the scrape fails")
+	}
+	if target.scrapeSuccess {
+		t.Error("expected scrape to be marked unsuccessful")
+	}
+	if target.lastErr == nil {
+		t.Error("expected lastErr to be set")
+	}
+}
+
+var errFetchFailed = context.DeadlineExceeded
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// scrapeSchemeFor resolves a target's scrape scheme from its
+// prometheus.auto.scheme label, defaulting to plain HTTP.
+func scrapeSchemeFor(labels map[string]string) string {
+	if labels["prometheus.auto.scheme"] == "https" {
+		return "https"
+	}
+	return "http"
+}
+
+// scrapeClientKey is the comparable subset of a target's TLS/auth labels
+// used to decide whether a cached *http.Client can be reused, without
+// re-reading any PEM or secret files just to check for changes.
+type scrapeClientKey struct {
+	scheme                string
+	insecureSkipVerify    bool
+	caFile                string
+	certFile              string
+	keyFile               string
+	serverName            string
+	basicAuthUsername     string
+	basicAuthPasswordFile string
+	bearerTokenFile       string
+}
+
+func scrapeClientKeyFromLabels(labels map[string]string) scrapeClientKey {
+	return scrapeClientKey{
+		scheme:                scrapeSchemeFor(labels),
+		insecureSkipVerify:    labels["prometheus.auto.tls.insecure_skip_verify"] == "true",
+		caFile:                labels["prometheus.auto.tls.ca_file"],
+		certFile:              labels["prometheus.auto.tls.cert_file"],
+		keyFile:               labels["prometheus.auto.tls.key_file"],
+		serverName:            labels["prometheus.auto.tls.server_name"],
+		basicAuthUsername:     labels["prometheus.auto.basic_auth.username"],
+		basicAuthPasswordFile: labels["prometheus.auto.basic_auth.password_file"],
+		bearerTokenFile:       labels["prometheus.auto.bearer_token_file"],
+	}
+}
+
+// scrapeAuthConfig holds the resolved (file-read) credentials to attach to
+// every request made with the matching cached client.
+type scrapeAuthConfig struct {
+	basicAuthUsername string
+	basicAuthPassword string
+	bearerToken       string
+}
+
+// cachedScrapeClient pairs a built *http.Client with the label key it was
+// built from, so scrapeClientFor can tell when a container's labels have
+// drifted and the client needs rebuilding.
+type cachedScrapeClient struct {
+	key    scrapeClientKey
+	client *http.Client
+	auth   scrapeAuthConfig
+}
+
+// buildScrapeClient builds an *http.Client and resolves any basic-auth
+// password / bearer token files for one distinct TLS/auth configuration.
+// PEM and secret files are only read here, not on every scrape. The client
+// sets no timeout of its own; scrapeTarget bounds each request through its
+// context with the target's (possibly widened) scrape timeout.
+func buildScrapeClient(key scrapeClientKey) (*http.Client, scrapeAuthConfig, error) {
+	client := &http.Client{}
+
+	if key.scheme == "https" {
+		tlsConfig := &tls.Config{InsecureSkipVerify: key.insecureSkipVerify, ServerName: key.serverName}
+
+		if key.caFile != "" {
+			pem, err := os.ReadFile(key.caFile)
+			if err != nil {
+				return nil, scrapeAuthConfig{}, fmt.Errorf("failed to read TLS CA file %s: %w", key.caFile, err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, scrapeAuthConfig{}, fmt.Errorf("failed to parse TLS CA file %s", key.caFile)
+			}
+			tlsConfig.RootCAs = pool
+		}
+
+		if key.certFile != "" || key.keyFile != "" {
+			cert, err := tls.LoadX509KeyPair(key.certFile, key.keyFile)
+			if err != nil {
+				return nil, scrapeAuthConfig{}, fmt.Errorf("failed to load TLS client cert/key: %w", err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+
+		client.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+	}
+
+	auth := scrapeAuthConfig{basicAuthUsername: key.basicAuthUsername}
+	if key.basicAuthPasswordFile != "" {
+		password, err := os.ReadFile(key.basicAuthPasswordFile)
+		if err != nil {
+			return nil, scrapeAuthConfig{}, fmt.Errorf("failed to read basic auth password file %s: %w", key.basicAuthPasswordFile, err)
+		}
+		auth.basicAuthPassword = strings.TrimSpace(string(password))
+	}
+	if key.bearerTokenFile != "" {
+		token, err := os.ReadFile(key.bearerTokenFile)
+		if err != nil {
+			return nil, scrapeAuthConfig{}, fmt.Errorf("failed to read bearer token file %s: %w", key.bearerTokenFile, err)
+		}
+		auth.bearerToken = strings.TrimSpace(string(token))
+	}
+
+	return client, auth, nil
+}
+
+// scrapeClientFor returns the cached *http.Client and resolved auth config
+// for a target, only rebuilding it (and re-reading any PEM/secret files)
+// when the target's TLS/auth labels have changed since the last scrape.
+func (mc *MetricsCollector) scrapeClientFor(tgt Target) (*http.Client, scrapeAuthConfig, error) {
+	key := scrapeClientKeyFromLabels(tgt.Labels)
+
+	mc.mu.RLock()
+	cached, ok := mc.scrapeClients[tgt.ID]
+	mc.mu.RUnlock()
+	if ok && cached.key == key {
+		return cached.client, cached.auth, nil
+	}
+
+	client, auth, err := buildScrapeClient(key)
+	if err != nil {
+		return nil, scrapeAuthConfig{}, err
+	}
+
+	mc.mu.Lock()
+	if mc.scrapeClients == nil {
+		mc.scrapeClients = make(map[string]*cachedScrapeClient)
+	}
+	mc.scrapeClients[tgt.ID] = &cachedScrapeClient{key: key, client: client, auth: auth}
+	mc.mu.Unlock()
+
+	return client, auth, nil
+}
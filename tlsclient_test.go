@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFetchMetricsNegotiatedOverHTTPSWithSkipVerify(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("tls_metric 1\n"))
+	}))
+	defer server.Close()
+
+	ip, port := splitTestServerAddr(t, server.URL)
+
+	mc := &MetricsCollector{}
+	tgt := Target{ID: "tls1", Labels: map[string]string{
+		"prometheus.auto.scheme":                   "https",
+		"prometheus.auto.tls.insecure_skip_verify": "true",
+	}}
+
+	body, _, err := mc.fetchMetricsNegotiated(context.Background(), tgt, ip, port, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(body, "tls_metric") {
+		t.Error("expected scraped body to contain tls_metric")
+	}
+}
+
+func TestFetchMetricsNegotiatedOverHTTPSRejectsUntrustedCertWithoutSkipVerify(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	ip, port := splitTestServerAddr(t, server.URL)
+
+	mc := &MetricsCollector{}
+	tgt := Target{ID: "tls2", Labels: map[string]string{"prometheus.auto.scheme": "https"}}
+
+	if _, _, err := mc.fetchMetricsNegotiated(context.Background(), tgt, ip, port, ""); err == nil {
+		t.Error("expected an error scraping an untrusted TLS server without skip-verify or a matching CA")
+	}
+}
+
+func TestFetchMetricsNegotiatedSendsBearerToken(t *testing.T) {
+	var gotAuth string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	ip, port := splitTestServerAddr(t, server.URL)
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("s3cr3t\n"), 0o600); err != nil {
+		t.Fatalf("failed to write token file: %v", err)
+	}
+
+	mc := &MetricsCollector{}
+	tgt := Target{ID: "tls3", Labels: map[string]string{
+		"prometheus.auto.scheme":                   "https",
+		"prometheus.auto.tls.insecure_skip_verify": "true",
+		"prometheus.auto.bearer_token_file":        tokenFile,
+	}}
+
+	if _, _, err := mc.fetchMetricsNegotiated(context.Background(), tgt, ip, port, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if gotAuth != "Bearer s3cr3t" {
+		t.Errorf("expected Authorization header %q, got %q", "Bearer s3cr3t", gotAuth)
+	}
+}
+
+func TestScrapeClientForCachesAndRebuildsOnLabelChange(t *testing.T) {
+	mc := &MetricsCollector{}
+	tgt := Target{ID: "c1", Labels: map[string]string{"prometheus.auto.scheme": "http"}}
+
+	client1, _, err := mc.scrapeClientFor(tgt)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	client2, _, err := mc.scrapeClientFor(tgt)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if client1 != client2 {
+		t.Error("expected an unchanged target to reuse the cached client")
+	}
+
+	tgt.Labels = map[string]string{"prometheus.auto.scheme": "https", "prometheus.auto.tls.insecure_skip_verify": "true"}
+	client3, _, err := mc.scrapeClientFor(tgt)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if client3 == client1 {
+		t.Error("expected changed TLS labels to rebuild the cached client")
+	}
+}
+
+// splitTestServerAddr splits an httptest server URL into the bare IP and
+// port fetchMetricsNegotiated expects, discarding the scheme it already
+// re-derives from the target's labels.
+func splitTestServerAddr(t *testing.T, rawURL string) (string, string) {
+	t.Helper()
+	withoutScheme := strings.TrimPrefix(strings.TrimPrefix(rawURL, "https://"), "http://")
+	host, port, err := net.SplitHostPort(withoutScheme)
+	if err != nil {
+		t.Fatalf("failed to split test server address %q: %v", rawURL, err)
+	}
+	return host, port
+}